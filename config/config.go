@@ -1,6 +1,9 @@
 package config
 
-import "time"
+import (
+    "os"
+    "time"
+)
 
 // Config is the log config
 type Config struct {
@@ -10,14 +13,49 @@ type Config struct {
     CallerSkip int
 }
 
-// WriteConfig is the local file config
+// WriteConfig is the local file config. The write mode and async options also work for the console output and
+// other outputs whose factories provide a WriteSyncer
 type WriteConfig struct {
     // LogPath is the log path like /tmp/log/
     LogPath string
     // FileName is the file name like test.log
     FileName string
-    // WriteWayMode is the log write mod. 1: sync, 2: async, 3: fast(maybe dropped)
+    // WriteWayMode is the log write mod. 1: sync, 2: async, 3: fast(maybe dropped).
+    // Default async for file output and sync for console output.
     WriteMode WriteWayMode
+    // QueueSize is the max number of logs waiting in the queue in async write modes, default 10000.
+    QueueSize int
+    // QueueShards is the number of queues which QueueSize is split into in async write modes, default 1.
+    // Multiple shards, like the number of CPUs, reduce the contention of many goroutines logging at the same time.
+    QueueShards int
+    // BatchSize is the size(KB) of logs written at a time in async write modes, default 4.
+    BatchSize int
+    // BatchInterval is the max interval(ms) to write the logs in the queue in async write modes, default 100.
+    BatchInterval int
+    // FlushLevel is the lowest level of logs which are flushed at once, together with the logs before them,
+    // in async write modes or with BufferSize. The file is also committed to disk by FsyncPolicy.
+    // e.g. LevelError makes error logs on disk right away. Default as none.
+    FlushLevel LogLevel
+    // Backpressure is what to do when the queue is full in async write modes, like block, block with timeout,
+    // drop newest, drop oldest or drop debug/info first. Default blocks in async mode and drops newest in fast mode.
+    Backpressure BackpressureType
+    // BlockTimeout is the max time(ms) to wait for the queue with BackpressureBlockTimeout policy, default 100.
+    BlockTimeout int
+    // SpillPath is the overflow file with BackpressureSpill policy, default as the log file path with suffix
+    // ".spill". It is required for console output.
+    SpillPath string
+    // SpillMaxSize is the max size of the overflow file(MB), logs are dropped beyond it. Default as 0 which
+    // means no limit.
+    SpillMaxSize int
+    // BufferSize is the buffer size(KB) in sync write mode, default as 0 which means no buffer.
+    BufferSize int
+    // FlushInterval is the interval(ms) to flush the buffer in sync write mode, default 1000.
+    FlushInterval int
+    // FsyncPolicy is when to commit log file to disk, like never/on sync/every interval/every write, default never.
+    FsyncPolicy FsyncPolicyType
+    // FsyncInterval is the interval(ms) to commit log file to disk with FsyncEveryInterval policy.
+    // Default as 0 which means every second.
+    FsyncInterval int
     // RollType is the log rolling type. split files by size/time, default by size
     RollType RollType
     // MaxAge is the max expire times(day)
@@ -26,11 +64,70 @@ type WriteConfig struct {
     MaxSize int
     // MaxBackups is the max backup files
     MaxBackups int
+    // MaxTotalSize is the max total size of current log file and all backups(MB), the oldest backups are
+    // removed first. Logs below error level are dropped if it is still exceeded. Default as 0 which means no limit.
+    MaxTotalSize int
+    // MinFreeDisk is the min free space of the disk which log files are on(MB), works the same as MaxTotalSize.
+    MinFreeDisk int
+    // CleanInterval is the interval(seconds) of retention sweeps, which remove expired or redundant backups
+    // and compress them even if the log file is rarely rotated. A sweep also runs when the file is opened.
+    // Default as 0 which means every hour, negative disables periodic sweeps.
+    CleanInterval int
     // Compress defines whether log should be compressed
     Compress bool
+    // CompressCodec is the compression algorithm, like gzip/zstd/lz4/snappy, default gzip
+    CompressCodec CompressCodecType
+    // CompressLevel is the compression level of codec, 1-9 for gzip and lz4, 1-22 for zstd and none for snappy.
+    // Default as 0 which means the codec's default level
+    CompressLevel int
+    // CompressConcurrency is the max number of files compressed at the same time, default 1
+    CompressConcurrency int
+    // CompressRateLimit is the max throughput of compressing(MB/s), default as 0 which means no limit
+    CompressRateLimit int
     // TimeUnit splits files by time unit, like year/month/hour/minute, default day.
     // It takes effect only when split by time.
     TimeUnit TimeUnit
+    // TimeInterval is the number of time units per file, e.g. 6 with TimeUnit hour splits files every 6 hours,
+    // default 1. It should divide the next larger unit, like 6 hours in a day.
+    TimeInterval int
+    // TimeZone is the location of time boundaries to split files, like "UTC" or "Asia/Shanghai", default local.
+    TimeZone string
+    // BackupNaming is the naming scheme of backup files, like timestamp or number, default timestamp.
+    BackupNaming BackupNamingMode
+    // BackupTimeFmt is the time layout of timestamp backup names, default as "bk-20060102-150405.00000".
+    BackupTimeFmt string
+    // BackupUTC defines whether the time of backup names uses UTC instead of local time.
+    BackupUTC bool
+    // StatInterval is the interval(ms) to check whether the log file is deleted or replaced externally, then
+    // recreates it. Default as 0 which checks only when reopening the file every 10 seconds.
+    StatInterval int
+    // Symlink is the path of a symlink which always points to the current log file, like ./test.log.
+    // It is useful when rolling by time, the path keeps constant for `tail -F` and log agents. A file other than
+    // a symlink at the path is never replaced. Default none.
+    Symlink string
+    // ArchiveDir is the directory which finished log files are moved into, like /tmp/log/archive/.
+    // Default none, which keeps them next to the current log file. It does not work with numbered backups.
+    ArchiveDir string
+    // ArchiveLayout is the sub directory layout in ArchiveDir by rolling time, default as "%Y/%m/%d".
+    ArchiveLayout string
+    // FileMode is the mode of log files, backups, compressed files and the spill file, like 0600, default 0666
+    // before umask.
+    FileMode os.FileMode
+    // DirMode is the mode of directories created for log files, like 0750, default 0755 before umask.
+    DirMode os.FileMode
+    // Uid is the owner user id of log files, the spill file and created directories, default as 0 which means
+    // unchanged.
+    Uid int
+    // Gid is the owner group id of log files, the spill file and created directories, default as 0 which means
+    // unchanged.
+    Gid int
+    // StatsLogInterval is the interval(seconds) to log a warning summarising the logs dropped in async write
+    // modes, if any. Default as 0 which means every minute, negative disables it.
+    StatsLogInterval int
+    // OnFileEvent is called on file events, like opened, rotated, compressed or deleted, e.g. to upload
+    // rotated files. It is called in a separate goroutine in order of events, events are dropped instead of
+    // blocking logging if it falls behind by more than 100 events. Default none.
+    OnFileEvent func(e FileEvent)
 }
 
 // FormatConfig is the log format config.
@@ -38,20 +135,20 @@ type FormatConfig struct {
     // TimeFmt is the time format of log output, default as "2006-01-02 15:04:05.000" on empty.
     TimeFmt string
 
-    // TimeKey is the time key of log output, default as "T".
+    // TimeKey is the time key of log output, default as "T", or "time" for logfmt.
     TimeKey string
-    // LevelKey is the level key of log output, default as "L".
+    // LevelKey is the level key of log output, default as "L", or "level" for logfmt.
     LevelKey string
-    // NameKey is the name key of log output, default as "N".
+    // NameKey is the name key of log output, default as "N", or "logger" for logfmt.
     NameKey string
-    // CallerKey is the caller key of log output, default as "C".
+    // CallerKey is the caller key of log output, default as "C", or "caller" for logfmt.
     CallerKey string
     // FunctionKey is the function key of log output, default as "", which means not to print
     // function name.
     FunctionKey string
-    // MessageKey is the message key of log output, default as "M".
+    // MessageKey is the message key of log output, default as "M", or "msg" for logfmt.
     MessageKey string
-    // StackTraceKey is the stack trace key of log output, default as "S".
+    // StackTraceKey is the stack trace key of log output, default as "S", or "stacktrace" for logfmt.
     StacktraceKey string
 }
 
@@ -67,6 +164,12 @@ type OutputConfig struct {
 
     // LogLevel controls the log level, like trace, debug, info or error
     Level LogLevel
+    // MaxLevel is the highest level written to this output, default as no upper bound.
+    // e.g. Level: LevelDebug and MaxLevel: LevelInfo writes only debug and info logs.
+    MaxLevel LogLevel
+    // Levels is the explicit set of levels written to this output, default as all levels.
+    // It is combined with Level and MaxLevel when they are set.
+    Levels []LogLevel
 }
 
 // ------------------- define ----------------------------
@@ -100,6 +203,8 @@ const (
     FormatterConsole FormatterMode = 1
     // FormatterJson formatter of json
     FormatterJson FormatterMode = 2
+    // FormatterLogfmt formatter of logfmt, like `time="2022-10-16 15:04:05.000" level=info msg=hello k=v`
+    FormatterLogfmt FormatterMode = 3
 )
 
 // WriteWayMode is the log write mode, one of 1, 2, 3
@@ -114,6 +219,40 @@ const (
     WriteFast WriteWayMode = 3
 )
 
+// FsyncPolicyType is when to commit log file to disk, one of 1, 2, 3, 4
+type FsyncPolicyType int
+
+const (
+    // FsyncNever never calls fsync, leaving it to the operating system
+    FsyncNever FsyncPolicyType = 1
+    // FsyncOnSync calls fsync on Sync
+    FsyncOnSync FsyncPolicyType = 2
+    // FsyncEveryInterval calls fsync every FsyncInterval and on Sync
+    FsyncEveryInterval FsyncPolicyType = 3
+    // FsyncEveryWrite calls fsync after every write to the file, e.g. for audit logs
+    FsyncEveryWrite FsyncPolicyType = 4
+)
+
+// BackpressureType is the policy when the queue is full in async write modes, one of 1, 2, 3, 4, 5, 6
+type BackpressureType int
+
+const (
+    // BackpressureBlock waits until the queue has room
+    BackpressureBlock BackpressureType = 1
+    // BackpressureDropNewest drops the log being written
+    BackpressureDropNewest BackpressureType = 2
+    // BackpressureBlockTimeout waits for at most BlockTimeout, then drops the log being written
+    BackpressureBlockTimeout BackpressureType = 3
+    // BackpressureDropOldest drops the oldest logs in the queue to make room
+    BackpressureDropOldest BackpressureType = 4
+    // BackpressureDropLowLevel drops debug and info logs once the queue is over 80% full, while warn and higher
+    // level logs are never dropped, they wait until the queue has room
+    BackpressureDropLowLevel BackpressureType = 5
+    // BackpressureSpill appends logs to the overflow file SpillPath while the queue is full, and writes them back
+    // in order once the queue is drained. The logs left in the overflow file by last run are written on start
+    BackpressureSpill BackpressureType = 6
+)
+
 // RollType is the log rolling type, one of 1, 2
 type RollType int
 
@@ -124,6 +263,96 @@ const (
     RollByTime RollType = 2
 )
 
+// CompressCodecType is the compression algorithm of log files, one of 1, 2, 3, 4
+type CompressCodecType int
+
+const (
+    // CompressGzip compresses by gzip, suffix ".gz"
+    CompressGzip CompressCodecType = 1
+    // CompressZstd compresses by zstd, suffix ".zst"
+    CompressZstd CompressCodecType = 2
+    // CompressLz4 compresses by lz4, suffix ".lz4"
+    CompressLz4 CompressCodecType = 3
+    // CompressSnappy compresses by snappy framing format, suffix ".sz"
+    CompressSnappy CompressCodecType = 4
+)
+
+// BackupNamingMode is the naming scheme of backup files, one of 1, 2
+type BackupNamingMode int
+
+const (
+    // BackupNameTimestamp names backups with the rolling time, like app.log.bk-20221016-150405.00000
+    BackupNameTimestamp BackupNamingMode = 1
+    // BackupNameNumber names backups with a number like logrotate, like app.log.1, app.log.2.gz
+    BackupNameNumber BackupNamingMode = 2
+)
+
+// FileEventType is the type of log file event, one of 1, 2, 3, 4, 5
+type FileEventType int
+
+const (
+    // FileOpened means a new log file is opened
+    FileOpened FileEventType = 1
+    // FileRotated means the current log file is finished by rolling
+    FileRotated FileEventType = 2
+    // FileCompressed means a log file is compressed
+    FileCompressed FileEventType = 3
+    // FileDeleted means a log file is deleted by scavenger
+    FileDeleted FileEventType = 4
+    // FileArchived means a log file is moved into the archive directory
+    FileArchived FileEventType = 5
+)
+
+// FileEvent is the event of log file
+type FileEvent struct {
+    // Type is the event type
+    Type FileEventType
+    // Path is the file path. On rotated it is where the finished file is, empty if the file is moved or deleted
+    // externally. On compressed it is the compressed file, on archived it is the file in archive directory
+    Path string
+    // OldPath is the former file path on rotated, compressed or archived, empty if the finished file is not
+    // moved on rotated by time, and for other events
+    OldPath string
+    // CurrPath is the current log file after rotated, empty for other events
+    CurrPath string
+    // Time is when the event happens
+    Time time.Time
+}
+
+// CleanPlan is what the retention sweep of a file output would do with the old log files
+type CleanPlan struct {
+    // Compress is the files to be compressed
+    Compress []string
+    // Remove is the files to be deleted
+    Remove []string
+}
+
+// AsyncStats is the statistics of an output in async write modes
+type AsyncStats struct {
+    // Queued is the number of logs put into the queue
+    Queued uint64
+    // Written is the number of logs written to the file
+    Written uint64
+    // Dropped is the number of logs dropped on queue full
+    Dropped uint64
+    // Bytes is the number of bytes written to the file
+    Bytes uint64
+    // QueueDepth is the number of logs waiting in the queue
+    QueueDepth int
+    // Flushes is the number of batch writes to the file
+    Flushes uint64
+    // FlushLatency is the average latency of batch writes
+    FlushLatency time.Duration
+    // MaxFlushLatency is the max latency of batch writes
+    MaxFlushLatency time.Duration
+    // WriteErrors is the number of failed batch writes
+    WriteErrors uint64
+    // Spilled is the number of logs appended to the overflow file
+    Spilled uint64
+    // SpillBacklog is the size(byte) of logs in the overflow file not written back yet
+    SpillBacklog int64
+}
+
 // LogLevel is the log level
 type LogLevel int
 
@@ -188,6 +417,7 @@ func (t TimeUnit) Format() string {
 }
 
 // RotationGap returns the time.Duration for time unit. Use one day as the default.
+// Deprecated: months and years are not fixed durations, use Truncate and Next instead.
 func (t TimeUnit) RotationGap() time.Duration {
     switch t {
     case Minute:
@@ -204,3 +434,53 @@ func (t TimeUnit) RotationGap() time.Duration {
         return time.Hour * 24
     }
 }
+
+// Truncate returns the start of the period of n time units which tm is in, in the location of tm.
+// Periods are aligned to the next larger unit, e.g. every 6 hours starts at 00:00, 06:00, 12:00 and 18:00.
+func (t TimeUnit) Truncate(tm time.Time, n int) time.Time {
+    if n <= 0 {
+        n = 1
+    }
+    year, month, day := tm.Date()
+    hour, minute, _ := tm.Clock()
+    loc := tm.Location()
+    switch t {
+    case Minute:
+        return time.Date(year, month, day, hour, minute-minute%n, 0, 0, loc)
+    case Hour:
+        return time.Date(year, month, day, hour-hour%n, 0, 0, 0, loc)
+    case Month:
+        return time.Date(year, month-(month-1)%time.Month(n), 1, 0, 0, 0, 0, loc)
+    case Year:
+        return time.Date(year-year%n, 1, 1, 0, 0, 0, 0, loc)
+    default:
+        return time.Date(year, month, day-(day-1)%n, 0, 0, 0, 0, loc)
+    }
+}
+
+// Next returns the start of the period of n time units after the one which tm is in.
+// Months and years are calendar correct.
+func (t TimeUnit) Next(tm time.Time, n int) time.Time {
+    if n <= 0 {
+        n = 1
+    }
+    start := t.Truncate(tm, n)
+    year, month, day := start.Date()
+    hour, minute, _ := start.Clock()
+    loc := start.Location()
+    var next time.Time
+    switch t {
+    case Minute:
+        next = time.Date(year, month, day, hour, minute+n, 0, 0, loc)
+    case Hour:
+        next = time.Date(year, month, day, hour+n, 0, 0, 0, loc)
+    case Month:
+        next = time.Date(year, month+time.Month(n), 1, 0, 0, 0, 0, loc)
+    case Year:
+        next = time.Date(year+n, 1, 1, 0, 0, 0, 0, loc)
+    default:
+        next = time.Date(year, month, day+n, 0, 0, 0, 0, loc)
+    }
+    // align to the next larger unit, e.g. every 7 days restarts on the first day of next month
+    return t.Truncate(next, n)
+}
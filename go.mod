@@ -3,6 +3,8 @@ module github.com/noahyzhang/zlog
 go 1.15
 
 require (
+	github.com/klauspost/compress v1.15.1
 	github.com/lestrrat-go/strftime v1.0.6
+	github.com/pierrec/lz4/v4 v4.1.14
 	go.uber.org/zap v1.21.0
 )
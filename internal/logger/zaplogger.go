@@ -1,7 +1,9 @@
 package logger
 
 import (
+    "context"
     "fmt"
+    "io"
     "go.uber.org/zap"
     "go.uber.org/zap/zapcore"
     "github.com/noahyzhang/zlog/config"
@@ -29,21 +31,55 @@ func NewZapLogWithCallerSkip(c config.Config, callerSkip int) Logger {
     var (
         cores []zapcore.Core
         levels []zap.AtomicLevel
+        rotators []writer.Rotator
+        planners []writer.Planner
+        closers []io.Closer
+        reporters []writer.StatsReporter
     )
     for _, o := range c.LogConfig {
         w := writer.GetWriter(o.WriterName)
         if w == nil {
             panic("log: writer core: " + o.WriterName.ToString() + " no registered")
         }
-        core, zapLevel, err := w.Setup(&o)
+        var (
+            core zapcore.Core
+            zapLevel zap.AtomicLevel
+            err error
+        )
+        if f, ok := w.(writer.WriteSyncerFactory); ok {
+            var ws zapcore.WriteSyncer
+            if ws, err = f.WriteSyncer(&o); err == nil {
+                // wrap the output by the write mode, e.g. write asynchronously
+                core, zapLevel = writer.NewCore(&o, ws)
+            }
+        } else {
+            core, zapLevel, err = w.Setup(&o)
+        }
         if err != nil {
             panic("log: writer core: " + o.WriterName.ToString() + " setup fail: " + err.Error())
         }
         cores = append(cores, core)
         levels = append(levels, zapLevel)
+        if r, ok := core.(writer.Rotator); ok {
+            rotators = append(rotators, r)
+        }
+        if p, ok := core.(writer.Planner); ok {
+            planners = append(planners, p)
+        }
+        if cl, ok := core.(io.Closer); ok {
+            closers = append(closers, cl)
+        }
+        if r, ok := core.(writer.StatsReporter); ok {
+            reporters = append(reporters, r)
+        }
     }
     return &zapLog{
+        cores: cores,
         levels: levels,
+        rotators: rotators,
+        planners: planners,
+        closers: closers,
+        reporters: reporters,
         logger: zap.New(
             zapcore.NewTee(cores...),
             zap.AddCallerSkip(callerSkip),
@@ -55,7 +91,12 @@ func NewZapLogWithCallerSkip(c config.Config, callerSkip int) Logger {
 
 // zapLog is a Logger implementation based on zapLogger
 type zapLog struct {
+    cores []zapcore.Core
     levels []zap.AtomicLevel
+    rotators []writer.Rotator
+    planners []writer.Planner
+    closers []io.Closer
+    reporters []writer.StatsReporter
     logger *zap.Logger
 }
 
@@ -70,7 +111,7 @@ func getLogMsgf(format string, args ...interface{}) string {
 // Debug logs to DEBUG log. Arguments are handled in the manner of fmt.Print
 func (l *zapLog) Debug(args ...interface{}) {
     if l.logger.Core().Enabled(zapcore.DebugLevel) {
-        l.logger.Debug(getLogMsg(args))
+        l.logger.Debug(getLogMsg(args...))
     }
 }
 
@@ -143,6 +184,102 @@ func (l *zapLog) Sync() error {
     return l.logger.Sync()
 }
 
+// SyncContext works like Sync, but returns the error of ctx if it is done before the logs are flushed.
+// Outputs in async write modes stop waiting for the flush at once when ctx is done
+func (l *zapLog) SyncContext(ctx context.Context) error {
+    var err error
+    for _, c := range l.cores {
+        if e := ctx.Err(); e != nil {
+            return e
+        }
+        var e error
+        if s, ok := c.(writer.ContextSyncer); ok {
+            e = s.SyncContext(ctx)
+        } else {
+            e = c.Sync()
+        }
+        if e != nil && err == nil {
+            err = e
+        }
+    }
+    return err
+}
+
+// Close flushes the logs and closes all file outputs, stopping their background goroutines.
+// The logger must not be used after closing
+func (l *zapLog) Close() error {
+    _ = l.logger.Sync()
+    var err error
+    for _, c := range l.closers {
+        if e := c.Close(); e != nil && err == nil {
+            err = e
+        }
+    }
+    return err
+}
+
+// Rotate backs up the current files of all file outputs and opens new ones
+func (l *zapLog) Rotate() error {
+    var err error
+    for _, r := range l.rotators {
+        if e := r.Rotate(); e != nil && err == nil {
+            err = e
+        }
+    }
+    return err
+}
+
+// Reopen reopens the current files of all file outputs
+func (l *zapLog) Reopen() error {
+    var err error
+    for _, r := range l.rotators {
+        if e := r.Reopen(); e != nil && err == nil {
+            err = e
+        }
+    }
+    return err
+}
+
+// Plan returns the files which the retention sweeps of file outputs would compress or delete now,
+// keyed by their file paths. It returns the first error of the outputs
+func (l *zapLog) Plan() (map[string]config.CleanPlan, error) {
+    plans := make(map[string]config.CleanPlan, len(l.planners))
+    var err error
+    for _, p := range l.planners {
+        plan, e := p.Plan()
+        if e != nil {
+            if err == nil {
+                err = e
+            }
+            continue
+        }
+        plans[p.FilePath()] = plan
+    }
+    return plans, err
+}
+
+// Stats returns the statistics of outputs in async write modes, keyed by their file paths
+func (l *zapLog) Stats() map[string]config.AsyncStats {
+    stats := make(map[string]config.AsyncStats, len(l.reporters))
+    for _, r := range l.reporters {
+        stats[r.Name()] = r.Stats()
+    }
+    return stats
+}
+
+// with returns a new zapLog with the fields, sharing the outputs
+func (l *zapLog) with(fields []zap.Field) *zapLog {
+    return &zapLog{
+        logger: l.logger.With(fields...),
+        cores: l.cores,
+        levels: l.levels,
+        rotators: l.rotators,
+        planners: l.planners,
+        closers: l.closers,
+        reporters: l.reporters,
+    }
+}
+
 // SetLevel sets output log level
 func (l *zapLog) SetLevel(level config.LogLevel) {
     for i := 0; i < len(l.levels); i++ {
@@ -170,7 +307,7 @@ func (l *zapLog) WithFields(fields ...string) Logger {
     }
     // By ZapLogWrapper proxy, we can add a layer to the debug series function calls, so that the
     // caller information can be set correctly.
-    return &ZapLogWrapper{l: &zapLog{logger: l.logger.With(zapFields...), levels: l.levels}}
+    return &ZapLogWrapper{l: l.with(zapFields)}
 }
 
 // With add user defined fields to Logger. Fields support multiple values
@@ -181,7 +318,7 @@ func (l *zapLog) With(fields ... Field) Logger {
     }
     // By ZapLogWrapper proxy, we can add a layer to the debug series function calls, so that the
     // caller information can be set correctly.
-    return &ZapLogWrapper{l: &zapLog{logger: l.logger.With(zapFields...), levels: l.levels}}
+    return &ZapLogWrapper{l: l.with(zapFields)}
 }
 
 // --------------------------- ZapLogWrapper ------------------------------------------
@@ -255,6 +392,36 @@ func (z *ZapLogWrapper) Sync() error {
     return z.l.Sync()
 }
 
+// SyncContext works like Sync, but returns the error of ctx if it is done before the logs are flushed.
+func (z *ZapLogWrapper) SyncContext(ctx context.Context) error {
+    return z.l.SyncContext(ctx)
+}
+
+// Close flushes the logs and closes all file outputs.
+func (z *ZapLogWrapper) Close() error {
+    return z.l.Close()
+}
+
+// Rotate backs up the current files of all file outputs and opens new ones.
+func (z *ZapLogWrapper) Rotate() error {
+    return z.l.Rotate()
+}
+
+// Reopen reopens the current files of all file outputs.
+func (z *ZapLogWrapper) Reopen() error {
+    return z.l.Reopen()
+}
+
+// Plan returns the files which the retention sweeps of file outputs would compress or delete now.
+func (z *ZapLogWrapper) Plan() (map[string]config.CleanPlan, error) {
+    return z.l.Plan()
+}
+
+// Stats returns the statistics of outputs in async write modes, keyed by their file paths.
+func (z *ZapLogWrapper) Stats() map[string]config.AsyncStats {
+    return z.l.Stats()
+}
+
 // SetLevel set output log level.
 func (z *ZapLogWrapper) SetLevel(level config.LogLevel) {
     z.l.SetLevel(level)
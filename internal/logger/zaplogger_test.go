@@ -0,0 +1,88 @@
+package logger
+
+import (
+    "context"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "github.com/noahyzhang/zlog/config"
+)
+
+func TestSyncContextWith(t *testing.T) {
+    dir := t.TempDir()
+    l := NewZapLog(config.Config{
+        LogConfig: []config.OutputConfig{
+            {
+                WriterName: config.OutputFile,
+                WriterConfig: config.WriteConfig{
+                    FileName: filepath.Join(dir, "test.log"),
+                    WriteMode: config.WriteAsync,
+                    RollType: config.RollBySize,
+                    // do not write the queued logs before SyncContext
+                    BatchInterval: 60 * 1000,
+                },
+                Formatter: config.FormatterJson,
+                Level: config.LevelDebug,
+            },
+        },
+        CallerSkip: 2,
+    })
+    defer l.(*zapLog).Close()
+
+    derived := l.With(Field{Key: "uid", Value: 1})
+    derived.Info("hello")
+    if err := derived.(*ZapLogWrapper).SyncContext(context.Background()); err != nil {
+        t.Fatalf("SyncContext: %v", err)
+    }
+    data, err := ioutil.ReadFile(filepath.Join(dir, "test.log"))
+    if err != nil {
+        t.Fatalf("read log file: %v", err)
+    }
+    if len(data) == 0 {
+        t.Fatal("SyncContext of the derived logger does not flush the logs")
+    }
+}
+
+func TestPlan(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "test.log")
+    l := NewZapLog(config.Config{
+        LogConfig: []config.OutputConfig{
+            {
+                WriterName: config.OutputFile,
+                WriterConfig: config.WriteConfig{
+                    FileName: path,
+                    RollType: config.RollBySize,
+                    MaxBackups: 1,
+                    Compress: true,
+                },
+                Formatter: config.FormatterJson,
+                Level: config.LevelDebug,
+            },
+        },
+        CallerSkip: 2,
+    })
+    // close first, so that no retention sweep runs in background
+    if err := l.(*zapLog).Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+    for _, name := range []string{"test.log.bk-20221016-150405.00000", "test.log.bk-20221015-150405.00000"} {
+        if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("log\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    plans, err := l.With(Field{Key: "uid", Value: 1}).(*ZapLogWrapper).Plan()
+    if err != nil {
+        t.Fatalf("Plan: %v", err)
+    }
+    want := config.CleanPlan{
+        Compress: []string{filepath.Join(dir, "test.log.bk-20221016-150405.00000")},
+        Remove: []string{filepath.Join(dir, "test.log.bk-20221015-150405.00000")},
+    }
+    if got := plans[path]; !reflect.DeepEqual(got, want) {
+        t.Errorf("Plan() = %v, want %v", got, want)
+    }
+}
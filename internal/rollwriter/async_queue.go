@@ -0,0 +1,164 @@
+package rollwriter
+
+import (
+    "sync"
+    "sync/atomic"
+)
+
+// slab is a reusable buffer holding many queued logs back to back, which is handed off to the writing
+// goroutine as a whole, so that queueing a log is a copy into it rather than a channel send
+type slab struct {
+    buf []byte
+    // ends are the end offsets of the logs in buf
+    ends []int
+    // stamps are the order of the logs across shards in sharded mode
+    stamps []uint64
+    // head is the index of the first log not written or dropped yet
+    head int
+}
+
+// len returns the number of logs not written or dropped yet
+func (s *slab) len() int {
+    return len(s.ends) - s.head
+}
+
+// offset returns the start offset of the i-th log
+func (s *slab) offset(i int) int {
+    if i == 0 {
+        return 0
+    }
+    return s.ends[i - 1]
+}
+
+// bytes returns the logs not written or dropped yet
+func (s *slab) bytes() []byte {
+    return s.buf[s.offset(s.head):]
+}
+
+// add appends a log, stamped in sharded mode
+func (s *slab) add(data []byte, stamp uint64, stamped bool) {
+    s.buf = append(s.buf, data...)
+    s.ends = append(s.ends, len(s.buf))
+    if stamped {
+        s.stamps = append(s.stamps, stamp)
+    }
+}
+
+// reset empties the slab
+func (s *slab) reset() {
+    s.buf, s.ends, s.stamps, s.head = s.buf[:0], s.ends[:0], s.stamps[:0], 0
+}
+
+// slabPool reuses slabs, so that queueing logs does not allocate
+var slabPool = sync.Pool{
+    New: func() interface{} {
+        return &slab{buf: make([]byte, 0, 8 * 1024)}
+    },
+}
+
+// maxPooledSlab is the max capacity of slabs put back to the pool, larger ones are left to GC
+// so that rare bursts do not pin memory
+const maxPooledSlab = 4 * 1024 * 1024
+
+// getSlab gets an empty slab from the pool
+func getSlab() *slab {
+    return slabPool.Get().(*slab)
+}
+
+// putSlab empties the slab and puts it back to the pool
+func putSlab(s *slab) {
+    if cap(s.buf) > maxPooledSlab {
+        return
+    }
+    s.reset()
+    slabPool.Put(s)
+}
+
+// queue is a log queue of AsyncRollWriter. Logs are appended to the current slab under the lock, and the
+// writing goroutine takes the slab with all of them at a time
+type queue struct {
+    mu sync.Mutex
+    curr *slab
+    // size is the max number of logs
+    size int
+    // stamped is whether the logs are stamped to merge shards, last is the stamp of the last log
+    stamped bool
+    last uint64
+    // busy is the number of writes waiting for room or spilling outside the lock, Close waits for them
+    busy int
+    // room is closed when the logs are taken, to wake up the writes waiting for room
+    room chan struct{}
+    // depth and queued are the number of logs in the queue and put into it, read by Stats
+    depth int64
+    queued uint64
+    // the padding keeps queues on different cache lines in sharded mode
+    _ [64]byte
+}
+
+// newQueue creates a queue holding at most size logs
+func newQueue(size int, stamped bool) *queue {
+    if size < 1 {
+        size = 1
+    }
+    return &queue{curr: getSlab(), size: size, stamped: stamped}
+}
+
+// full checks whether the queue reaches percent of its size
+func (q *queue) full(percent int) bool {
+    return q.curr.len() * 100 >= q.size * percent
+}
+
+// add appends a log. The stamps of a queue are kept increasing, so that its logs are merged in order
+func (q *queue) add(data []byte, stamp uint64) {
+    if q.stamped {
+        if stamp <= q.last {
+            stamp = q.last + 1
+        }
+        q.last = stamp
+    }
+    q.curr.add(data, stamp, q.stamped)
+    atomic.StoreInt64(&q.depth, int64(q.curr.len()))
+    atomic.StoreUint64(&q.queued, q.queued + 1)
+}
+
+// dropOldest drops the oldest log
+func (q *queue) dropOldest() {
+    q.curr.head++
+    atomic.StoreInt64(&q.depth, int64(q.curr.len()))
+}
+
+// waitRoom returns the channel closed when the logs are taken
+func (q *queue) waitRoom() chan struct{} {
+    if q.room == nil {
+        q.room = make(chan struct{})
+    }
+    return q.room
+}
+
+// take takes the slab of queued logs and replaces it with an empty one, waking up the writes waiting for
+// room. It returns nil if the queue is empty
+func (q *queue) take() *slab {
+    empty := getSlab()
+    q.mu.Lock()
+    s := q.curr
+    if s.len() == 0 {
+        q.mu.Unlock()
+        putSlab(empty)
+        return nil
+    }
+    q.curr = empty
+    atomic.StoreInt64(&q.depth, 0)
+    if q.room != nil {
+        close(q.room)
+        q.room = nil
+    }
+    q.mu.Unlock()
+    return s
+}
+
+// isBusy checks whether any writes are waiting for room or spilling
+func (q *queue) isBusy() bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.busy > 0
+}
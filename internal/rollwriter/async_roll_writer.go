@@ -1,19 +1,95 @@
 package rollwriter
 
 import (
-    "bytes"
+    "context"
     "errors"
     "io"
+    "math"
+    "sync"
+    "sync/atomic"
     "time"
 )
 
 // AsyncRollWriter is the asynchronous rolling log writer which implements zapcore.WriteSyncer
 type AsyncRollWriter struct {
     logger io.Writer
+    // buffers is the logger if it writes many buffers at a time
+    buffers BuffersWriter
     opts *AsyncOptions
 
-    logQueue chan []byte
-    syncChan chan struct{}
+    // queues are the shards in sharded mode, or the only queue otherwise
+    queues []*queue
+    // shardPool hands out the shards in sharded mode. As sync.Pool caches items per P, the goroutines
+    // running on a P mostly write to the same shard without sharing any counter with other Ps
+    shardPool sync.Pool
+    // next is the index of the shard handed out to a P which has none cached
+    next uint32
+    // start is the base time of stamps in sharded mode
+    start time.Time
+    // waking is whether the writing goroutine is notified by wakeChan
+    waking int32
+    wakeChan chan struct{}
+    // pending are the logs taken from each shard but not written yet, only accessed by the writing goroutine
+    pending [][]*slab
+    pendingDepth int64
+    // bufs and scratch are reused to write the logs, only accessed by the writing goroutine
+    bufs [][]byte
+    scratch []byte
+    // done are the slabs written in a merge, only accessed by the writing goroutine
+    done []*slab
+    // err is the first write error since last flush, only accessed by the writing goroutine
+    err error
+    // spill is the overflow file with Spill policy
+    spill *spill
+    syncChan chan chan error
+    closeChan chan struct{}
+    doneChan chan struct{}
+
+    closed int32
+    // closeErr is the error of the last flush on closing
+    closeErr error
+
+    // statistics, updated atomically
+    written uint64
+    dropped uint64
+    bytes uint64
+    flushes uint64
+    flushNanos uint64
+    maxFlushNanos uint64
+    writeErrors uint64
+    spilled uint64
+}
+
+// BuffersWriter is implemented by the underlying writers which write many buffers at a time, like RollWriter
+// with writev. AsyncRollWriter writes the queued logs by it without copying them into one buffer
+type BuffersWriter interface {
+    WriteBuffers(bufs [][]byte) (int, error)
+}
+
+// AsyncStats is the statistics of AsyncRollWriter
+type AsyncStats struct {
+    // Queued is the number of logs put into the queue
+    Queued uint64
+    // Written is the number of logs written to the underlying writer
+    Written uint64
+    // Dropped is the number of logs dropped on queue full
+    Dropped uint64
+    // Bytes is the number of bytes written to the underlying writer
+    Bytes uint64
+    // QueueDepth is the number of logs waiting in the queue
+    QueueDepth int
+    // Flushes is the number of batch writes to the underlying writer
+    Flushes uint64
+    // FlushLatency is the average latency of batch writes
+    FlushLatency time.Duration
+    // MaxFlushLatency is the max latency of batch writes
+    MaxFlushLatency time.Duration
+    // WriteErrors is the number of failed batch writes
+    WriteErrors uint64
+    // Spilled is the number of logs appended to the spill file
+    Spilled uint64
+    // SpillBacklog is the size(byte) of spilled logs not written back yet
+    SpillBacklog int64
 }
 
 // NewAsyncRollWriter create a new AsyncRollWriter
@@ -30,8 +106,29 @@ func NewAsyncRollWriter(logger io.Writer, opt ...AsyncOption) *AsyncRollWriter {
     w := &AsyncRollWriter{
         logger: logger,
         opts: opts,
-        logQueue: make(chan []byte, opts.LogQueueSize),
-        syncChan: make(chan struct{}),
+        wakeChan: make(chan struct{}, 1),
+        syncChan: make(chan chan error),
+        closeChan: make(chan struct{}),
+        doneChan: make(chan struct{}),
+    }
+    w.buffers, _ = logger.(BuffersWriter)
+    if opts.Backpressure == Spill && opts.SpillPath != "" {
+        // block like BlockForever if fails to open
+        w.spill, _ = openSpill(opts.SpillPath, opts.SpillMaxSize, opts.SpillFileMode, opts.SpillUid, opts.SpillGid)
+    }
+    if opts.Shards > 1 {
+        // split the queue size among shards
+        w.queues = make([]*queue, opts.Shards)
+        for i := range w.queues {
+            w.queues[i] = newQueue(opts.LogQueueSize / opts.Shards, true)
+        }
+        w.pending = make([][]*slab, opts.Shards)
+        w.start = time.Now()
+        w.shardPool.New = func() interface{} {
+            return w.queues[atomic.AddUint32(&w.next, 1) % uint32(len(w.queues))]
+        }
+    } else {
+        w.queues = []*queue{newQueue(opts.LogQueueSize, false)}
     }
     go w.batchWriteLog()
     return w
@@ -39,58 +136,339 @@ func NewAsyncRollWriter(logger io.Writer, opt ...AsyncOption) *AsyncRollWriter {
 
 // Write writes logs. It implements io.Writer
 func (w *AsyncRollWriter) Write(data []byte) (int, error) {
-    log := make([]byte, len(data))
-    copy(log, data)
-    if w.opts.DropLog {
-        select {
-        case w.logQueue <- log:
-        default:
-            return 0, errors.New("log queue is full")
-        }
-    } else {
-        w.logQueue <- log
+    return w.WritePriority(data, false)
+}
+
+// WritePriority writes logs with priority, high priority logs are kept by DropLowPriority policy
+// when log queue is full
+func (w *AsyncRollWriter) WritePriority(data []byte, high bool) (int, error) {
+    if atomic.LoadInt32(&w.closed) == 1 {
+        return 0, ErrClosed
+    }
+    q := w.queues[0]
+    if len(w.queues) > 1 {
+        q = w.shardPool.Get().(*queue)
+    }
+    ok, err := w.enqueue(q, data, high)
+    if len(w.queues) > 1 {
+        w.shardPool.Put(q)
+    }
+    if err != nil {
+        return 0, err
+    }
+    if !ok {
+        atomic.AddUint64(&w.dropped, 1)
+        return 0, errors.New("log queue is full")
     }
     return len(data), nil
 }
 
-// Sync syncs logs. It implements zapcore.WriteSyncer
+// enqueue copies the log into the queue by the backpressure policy, returns false if it is dropped
+func (w *AsyncRollWriter) enqueue(q *queue, data []byte, high bool) (bool, error) {
+    var timer *time.Timer
+    q.mu.Lock()
+    // check under the lock, so that the logs are either taken by the last flush on closing or rejected
+    if atomic.LoadInt32(&w.closed) == 1 {
+        q.mu.Unlock()
+        return false, ErrClosed
+    }
+    for {
+        // spill all the logs until the spilled ones are written back, in order to keep the order
+        if w.spill != nil && (w.spill.isActive() || q.full(100)) {
+            q.busy++
+            q.mu.Unlock()
+            ok := w.spill.write(data)
+            if ok {
+                atomic.AddUint64(&w.spilled, 1)
+            }
+            q.mu.Lock()
+            q.busy--
+            q.mu.Unlock()
+            return ok, nil
+        }
+        if !q.full(100) && (high || w.opts.Backpressure != DropLowPriority || !q.full(80)) {
+            q.add(data, w.stamp(q))
+            // write the logs once WriteLogSize is queued, or half of the queue is used by small logs
+            wake := len(q.curr.buf) - q.curr.offset(q.curr.head) >= w.opts.WriteLogSize || q.full(50)
+            q.mu.Unlock()
+            if timer != nil {
+                timer.Stop()
+            }
+            if wake {
+                w.wake()
+            }
+            return true, nil
+        }
+        switch w.opts.Backpressure {
+        case DropNewest:
+            q.mu.Unlock()
+            return false, nil
+        case DropLowPriority:
+            // high priority logs wait until the queue has room
+            if !high {
+                q.mu.Unlock()
+                return false, nil
+            }
+        case DropOldest:
+            q.dropOldest()
+            atomic.AddUint64(&w.dropped, 1)
+            continue
+        }
+        // wait until the writing goroutine takes the logs
+        room := q.waitRoom()
+        q.busy++
+        q.mu.Unlock()
+        w.wake()
+        timeout := false
+        if w.opts.Backpressure == BlockTimeout {
+            if timer == nil {
+                timer = time.NewTimer(w.opts.BlockTimeout)
+            }
+            select {
+            case <-room:
+            case <-timer.C:
+                timeout = true
+            }
+        } else {
+            <-room
+        }
+        q.mu.Lock()
+        q.busy--
+        if timeout {
+            q.mu.Unlock()
+            return false, nil
+        }
+    }
+}
+
+// stamp returns the time since start in sharded mode, which orders the logs across shards when merging.
+// It is called under the lock of the queue
+func (w *AsyncRollWriter) stamp(q *queue) uint64 {
+    if !q.stamped {
+        return 0
+    }
+    return uint64(time.Since(w.start))
+}
+
+// Stats returns the statistics of writing
+func (w *AsyncRollWriter) Stats() AsyncStats {
+    s := AsyncStats{
+        Written: atomic.LoadUint64(&w.written),
+        Dropped: atomic.LoadUint64(&w.dropped),
+        Bytes: atomic.LoadUint64(&w.bytes),
+        Flushes: atomic.LoadUint64(&w.flushes),
+        MaxFlushLatency: time.Duration(atomic.LoadUint64(&w.maxFlushNanos)),
+        WriteErrors: atomic.LoadUint64(&w.writeErrors),
+        Spilled: atomic.LoadUint64(&w.spilled),
+    }
+    for _, q := range w.queues {
+        s.Queued += atomic.LoadUint64(&q.queued)
+    }
+    if w.spill != nil {
+        s.SpillBacklog = w.spill.backlog()
+    }
+    s.QueueDepth = w.queueDepth()
+    if s.Flushes > 0 {
+        s.FlushLatency = time.Duration(atomic.LoadUint64(&w.flushNanos) / s.Flushes)
+    }
+    return s
+}
+
+// queueDepth returns the number of logs in all the queues, including the ones taken but not written yet
+func (w *AsyncRollWriter) queueDepth() int {
+    n := atomic.LoadInt64(&w.pendingDepth)
+    for _, q := range w.queues {
+        n += atomic.LoadInt64(&q.depth)
+    }
+    return int(n)
+}
+
+// Sync syncs logs. It implements zapcore.WriteSyncer.
+// It blocks until all the logs written before are written to the underlying writer, which commits them to disk
+// by its fsync policy, and returns the first write error since last Sync
 func (w *AsyncRollWriter) Sync() error {
-    w.syncChan <- struct{}{}
-    return nil
+    return w.SyncContext(context.Background())
+}
+
+// SyncContext works like Sync, but returns the error of ctx if it is done before the logs are written
+func (w *AsyncRollWriter) SyncContext(ctx context.Context) error {
+    errCh := make(chan error, 1)
+    select {
+    case w.syncChan <- errCh:
+    case <-w.doneChan:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+    select {
+    case err := <-errCh:
+        return err
+    case <-ctx.Done():
+        return ctx.Err()
+    }
 }
 
-// Close closes current log file. It implement io.Closer
+// Close writes all the queued logs, stops the writing goroutine and closes the underlying writer.
+// Later writes return ErrClosed. It implement io.Closer
 func (w *AsyncRollWriter) Close() error {
-    return w.Sync()
+    if !atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+        return nil
+    }
+    close(w.closeChan)
+    <-w.doneChan
+    err := w.closeErr
+    if w.spill != nil {
+        if e := w.spill.close(); e != nil && err == nil {
+            err = e
+        }
+    }
+    if c, ok := w.logger.(io.Closer); ok {
+        if e := c.Close(); e != nil && err == nil {
+            err = e
+        }
+    }
+    return err
 }
 
-// batchWriteLog asynchronously writers logs in batches
+// batchWriteLog asynchronously writers logs in batches, every WriteLogInterval or once WriteLogSize is queued
 func (w *AsyncRollWriter) batchWriteLog() {
-    buffer := bytes.NewBuffer(make([]byte, 0, w.opts.WriteLogSize * 2))
+    defer close(w.doneChan)
     ticker := time.NewTicker(time.Millisecond * time.Duration(w.opts.WriteLogInterval))
+    defer ticker.Stop()
     for {
         select {
-        case <- ticker.C:
-            if buffer.Len() > 0 {
-                _, _ = w.logger.Write(buffer.Bytes())
-                buffer.Reset()
-            }
-        case data := <-w.logQueue:
-            buffer.Write(data)
-            if buffer.Len() >= w.opts.WriteLogSize {
-                _, _ = w.logger.Write(buffer.Bytes())
-                buffer.Reset()
-            }
-        case <-w.syncChan:
-            if buffer.Len() > 0 {
-                _, _ = w.logger.Write(buffer.Bytes())
-                buffer.Reset()
+        case <-ticker.C:
+            w.writeQueued()
+            if w.queueDepth() == 0 {
+                w.replaySpill()
             }
-            size := len(w.logQueue)
-            for i := 0; i < size; i++ {
-                v := <- w.logQueue
-                _, _ = w.logger.Write(v)
+        case <-w.wakeChan:
+            w.writeQueued()
+        case errCh := <-w.syncChan:
+            errCh <- w.flush()
+        case <-w.closeChan:
+            // let the writes waiting for room or spilling finish, no more writes start after closed
+            for w.isBusy() {
+                w.writeQueued()
+                time.Sleep(time.Millisecond)
             }
+            w.writeAll()
+            w.closeErr = w.flush()
+            return
+        }
+    }
+}
+
+// wake notifies the writing goroutine to write the queued logs, at most once until it takes them
+func (w *AsyncRollWriter) wake() {
+    if atomic.LoadInt32(&w.waking) == 0 && atomic.CompareAndSwapInt32(&w.waking, 0, 1) {
+        select {
+        case w.wakeChan <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// isBusy checks whether any writes are waiting for room or spilling
+func (w *AsyncRollWriter) isBusy() bool {
+    for _, q := range w.queues {
+        if q.isBusy() {
+            return true
+        }
+    }
+    return false
+}
+
+// writeQueued takes the queued logs and writes them at a time
+func (w *AsyncRollWriter) writeQueued() {
+    if len(w.queues) > 1 {
+        w.mergeShards(uint64(time.Since(w.start)))
+        return
+    }
+    atomic.StoreInt32(&w.waking, 0)
+    if s := w.queues[0].take(); s != nil {
+        w.writeSlab(s)
+        putSlab(s)
+    }
+}
+
+// writeAll writes all the queued logs, including the ones kept pending for later merges in sharded mode.
+// It is called on closing, when no more logs are queued
+func (w *AsyncRollWriter) writeAll() {
+    if len(w.queues) == 1 {
+        w.writeQueued()
+        return
+    }
+    w.mergeShards(math.MaxUint64)
+    for atomic.LoadInt64(&w.pendingDepth) > 0 {
+        w.mergeShards(math.MaxUint64)
+    }
+}
+
+// writeSlab writes the logs in the slab at a time, and empties it
+func (w *AsyncRollWriter) writeSlab(s *slab) {
+    if s.len() == 0 {
+        return
+    }
+    w.bufs = append(w.bufs[:0], s.bytes())
+    w.writeLogs(w.bufs, s.len())
+    s.reset()
+}
+
+// writeLogs writes n logs in bufs to the underlying writer at a time, and records the statistics
+func (w *AsyncRollWriter) writeLogs(bufs [][]byte, n int) {
+    if len(bufs) == 0 {
+        return
+    }
+    start := time.Now()
+    var (
+        written int
+        err error
+    )
+    switch {
+    case len(bufs) == 1:
+        written, err = w.logger.Write(bufs[0])
+    case w.buffers != nil:
+        written, err = w.buffers.WriteBuffers(bufs)
+    default:
+        w.scratch = w.scratch[:0]
+        for _, b := range bufs {
+            w.scratch = append(w.scratch, b...)
+        }
+        written, err = w.logger.Write(w.scratch)
+    }
+    cost := uint64(time.Since(start))
+    atomic.AddUint64(&w.flushes, 1)
+    atomic.AddUint64(&w.flushNanos, cost)
+    if cost > atomic.LoadUint64(&w.maxFlushNanos) {
+        atomic.StoreUint64(&w.maxFlushNanos, cost)
+    }
+    atomic.AddUint64(&w.bytes, uint64(written))
+    if err != nil {
+        atomic.AddUint64(&w.writeErrors, 1)
+        if w.err == nil {
+            w.err = err
+        }
+    } else {
+        atomic.AddUint64(&w.written, uint64(n))
+    }
+    // do not keep the written slabs referenced
+    for i := range bufs {
+        bufs[i] = nil
+    }
+}
+
+// flush writes all the queued logs, and syncs the underlying writer. It returns the first error since last flush
+func (w *AsyncRollWriter) flush() error {
+    w.writeQueued()
+    w.replaySpill()
+    err := w.err
+    w.err = nil
+    // commit to disk by the fsync policy of underlying writer
+    if s, ok := w.logger.(interface{ Sync() error }); ok {
+        if e := s.Sync(); e != nil && err == nil {
+            err = e
         }
     }
+    return err
 }
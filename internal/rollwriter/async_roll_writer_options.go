@@ -1,5 +1,10 @@
 package rollwriter
 
+import (
+    "os"
+    "time"
+)
+
 // AsyncOptions is the call options of AsyncRollWriter.
 type AsyncOptions struct {
     // LogQueueSize is the queue size of asynchronous log.
@@ -12,9 +17,56 @@ type AsyncOptions struct {
     WriteLogInterval int
 
     // DropLog determines whether to discard logs when log queue is full.
+    // Deprecated: use Backpressure instead, DropLog is the same as DropNewest.
     DropLog bool
+
+    // Backpressure is what to do when log queue is full, default BlockForever.
+    Backpressure Backpressure
+
+    // BlockTimeout is the max time to wait for the queue with BlockTimeout policy.
+    BlockTimeout time.Duration
+
+    // SpillPath is the path of the spill file with Spill policy.
+    SpillPath string
+
+    // SpillMaxSize is the max size(byte) of the spill file, default as 0 which means no limit.
+    SpillMaxSize int64
+
+    // SpillFileMode is the mode of the spill file, default 0666 before umask.
+    SpillFileMode os.FileMode
+
+    // SpillUid and SpillGid are the owner of the spill file, default as 0 which means unchanged.
+    SpillUid int
+    SpillGid int
+
+    // Shards is the number of queues which LogQueueSize is split into, default 1. Multiple shards reduce
+    // the contention of many goroutines writing at the same time, as the goroutines running on a P mostly
+    // write to the same shard.
+    Shards int
 }
 
+// Backpressure is the policy when log queue is full
+type Backpressure int
+
+const (
+    // BlockForever waits until the queue has room
+    BlockForever Backpressure = iota
+    // DropNewest drops the log being written
+    DropNewest
+    // BlockTimeout waits until the queue has room for at most BlockTimeout, then drops the log being written
+    BlockTimeout
+    // DropOldest drops the oldest logs in the queue to make room
+    DropOldest
+    // DropLowPriority drops low priority logs like debug and info once the queue is over 80% full, so that
+    // high priority logs like warn and error have room. High priority logs are never dropped, they wait
+    // until the queue has room
+    DropLowPriority
+    // Spill appends logs to the spill file while the queue is full, and writes them back in order once the
+    // queue is drained. It blocks like BlockForever if the spill file can't be opened, and drops logs once
+    // the spill file reaches SpillMaxSize
+    Spill
+)
+
 // AsyncOption modifies the AsyncOptions.
 type AsyncOption func(*AsyncOptions)
 
@@ -43,5 +95,43 @@ func WithWriteLogInterval(n int) AsyncOption {
 func WithDropLog(b bool) AsyncOption {
     return func(o *AsyncOptions) {
         o.DropLog = b
+        if b {
+            o.Backpressure = DropNewest
+        }
+    }
+}
+
+// WithBackpressure returns an AsyncOption which sets the policy on log queue full, and the max time(ms)
+// to wait for BlockTimeout policy.
+func WithBackpressure(p Backpressure, timeout int) AsyncOption {
+    return func(o *AsyncOptions) {
+        o.Backpressure = p
+        o.BlockTimeout = time.Duration(timeout) * time.Millisecond
+    }
+}
+
+// WithShards returns an AsyncOption which sets the number of queues, e.g. runtime.GOMAXPROCS(0) for
+// many goroutines writing at the same time.
+func WithShards(n int) AsyncOption {
+    return func(o *AsyncOptions) {
+        o.Shards = n
+    }
+}
+
+// WithSpill returns an AsyncOption which sets the path and max size(MB) of the spill file with Spill policy.
+func WithSpill(path string, maxSize int) AsyncOption {
+    return func(o *AsyncOptions) {
+        o.SpillPath = path
+        o.SpillMaxSize = int64(maxSize) * 1024 * 1024
+    }
+}
+
+// WithSpillPerm returns an AsyncOption which sets the mode and owner of the spill file, usually the same as
+// the log file as it holds the same logs.
+func WithSpillPerm(mode os.FileMode, uid, gid int) AsyncOption {
+    return func(o *AsyncOptions) {
+        o.SpillFileMode = mode
+        o.SpillUid = uid
+        o.SpillGid = gid
     }
 }
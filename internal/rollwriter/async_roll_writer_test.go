@@ -0,0 +1,97 @@
+package rollwriter
+
+import (
+    "bytes"
+    "io/ioutil"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// benchLog is a typical json log of about 200 bytes
+var benchLog = []byte(`{"L":"INFO","T":"2022-10-16 15:04:05.000","C":"rollwriter/async_roll_writer_test.go:12",` +
+    `"M":"request finished","method":"GET","path":"/api/v1/users","status":200,"cost":"1.234ms"}` + "\n")
+
+// benchmarkWrite writes benchLog to w by parallelism*GOMAXPROCS goroutines, or one goroutine if parallelism is 0,
+// and syncs at the end, so that the throughput includes writing out
+func benchmarkWrite(b *testing.B, w *AsyncRollWriter, parallelism int) {
+    b.ReportAllocs()
+    b.SetBytes(int64(len(benchLog)))
+    b.ResetTimer()
+    if parallelism == 0 {
+        for i := 0; i < b.N; i++ {
+            _, _ = w.Write(benchLog)
+        }
+    } else {
+        b.SetParallelism(parallelism)
+        b.RunParallel(func(pb *testing.PB) {
+            for pb.Next() {
+                _, _ = w.Write(benchLog)
+            }
+        })
+    }
+    _ = w.Sync()
+    b.StopTimer()
+    _ = w.Close()
+}
+
+// writersParallelism returns the parallelism to run at least n goroutines
+func writersParallelism(n int) int {
+    procs := runtime.GOMAXPROCS(0)
+    return (n + procs - 1) / procs
+}
+
+func BenchmarkAsyncWrite(b *testing.B) {
+    benchmarkWrite(b, NewAsyncRollWriter(ioutil.Discard), 0)
+}
+
+func BenchmarkAsyncWriteParallel(b *testing.B) {
+    benchmarkWrite(b, NewAsyncRollWriter(ioutil.Discard), 1)
+}
+
+func BenchmarkAsyncWriteFile(b *testing.B) {
+    rw, err := NewRollWriter(filepath.Join(b.TempDir(), "bench.log"))
+    if err != nil {
+        b.Fatal(err)
+    }
+    benchmarkWrite(b, NewAsyncRollWriter(rw), 1)
+}
+
+func BenchmarkAsyncWrite64Writers(b *testing.B) {
+    for _, shards := range []int{1, 8} {
+        b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+            benchmarkWrite(b, NewAsyncRollWriter(ioutil.Discard, WithShards(shards)), writersParallelism(64))
+        })
+    }
+}
+
+func BenchmarkAsyncWriteFile64Writers(b *testing.B) {
+    for _, shards := range []int{1, 8} {
+        b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+            rw, err := NewRollWriter(filepath.Join(b.TempDir(), "bench.log"))
+            if err != nil {
+                b.Fatal(err)
+            }
+            benchmarkWrite(b, NewAsyncRollWriter(rw, WithShards(shards)), writersParallelism(64))
+        })
+    }
+}
+
+func TestAsyncCloseWritesPending(t *testing.T) {
+    var buf bytes.Buffer
+    w := NewAsyncRollWriter(&buf, WithShards(2))
+    _, _ = w.Write([]byte("first\n"))
+    // a log stamped later than the merges before closing, e.g. by a coarse clock, is kept pending by them
+    q := w.queues[1]
+    q.mu.Lock()
+    q.add([]byte("late\n"), uint64(time.Hour))
+    q.mu.Unlock()
+    if err := w.Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+    if got := buf.String(); got != "first\nlate\n" {
+        t.Errorf("written %q", got)
+    }
+}
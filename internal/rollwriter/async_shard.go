@@ -0,0 +1,79 @@
+package rollwriter
+
+import (
+    "math"
+    "sync/atomic"
+)
+
+// mergeShards takes the logs from all shards and writes the ones stamped lower than limit in order of stamps.
+//
+// A log is stamped with the monotonic time under the lock of its shard. The limit is the time loaded before
+// taking the shards, so every log stamped lower than it is queued before its shard is taken, and every log
+// queued after that is stamped no lower than it. So the logs lower than the limit are written in order, while
+// the others are kept pending for the next merge. The logs of different goroutines are in time order, and the
+// logs of a goroutine are in order even if it moves to another shard, as the clock advances between them.
+// The logs of a shard in a row are written as one buffer.
+func (w *AsyncRollWriter) mergeShards(limit uint64) {
+    atomic.StoreInt32(&w.waking, 0)
+    depth := 0
+    for i, q := range w.queues {
+        if s := q.take(); s != nil {
+            w.pending[i] = append(w.pending[i], s)
+        }
+        for _, s := range w.pending[i] {
+            depth += s.len()
+        }
+    }
+    atomic.StoreInt64(&w.pendingDepth, int64(depth))
+    bufs, done, n := w.bufs[:0], w.done[:0], 0
+    for {
+        // find the shard with the lowest stamp, and the next lowest stamp of other shards
+        first, lowest, next := -1, uint64(0), uint64(math.MaxUint64)
+        for i, p := range w.pending {
+            if len(p) == 0 {
+                continue
+            }
+            stamp := p[0].stamps[p[0].head]
+            if first < 0 || stamp < lowest {
+                if first >= 0 {
+                    next = lowest
+                }
+                first, lowest = i, stamp
+            } else if stamp < next {
+                next = stamp
+            }
+        }
+        if first < 0 || lowest >= limit {
+            break
+        }
+        if limit < next {
+            next = limit
+        }
+        // write the logs of the shard in a row until the next lowest stamp
+        s := w.pending[first][0]
+        from := s.head
+        for s.head++; s.head < len(s.ends) && s.stamps[s.head] < next; s.head++ {
+        }
+        bufs = append(bufs, s.buf[s.offset(from):s.offset(s.head)])
+        n += s.head - from
+        if s.len() == 0 {
+            done = append(done, s)
+            p := w.pending[first]
+            copy(p, p[1:])
+            p[len(p) - 1] = nil
+            w.pending[first] = p[:len(p) - 1]
+        }
+    }
+    w.bufs = bufs
+    w.writeLogs(bufs, n)
+    atomic.AddInt64(&w.pendingDepth, -int64(n))
+    for i, s := range done {
+        putSlab(s)
+        done[i] = nil
+    }
+    w.done = done
+    // merge again for the pending logs
+    if atomic.LoadInt64(&w.pendingDepth) > 0 {
+        w.wake()
+    }
+}
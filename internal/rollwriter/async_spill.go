@@ -0,0 +1,178 @@
+package rollwriter
+
+import (
+    "bufio"
+    "encoding/binary"
+    "io"
+    "os"
+    "sync"
+    "sync/atomic"
+)
+
+// spill is the overflow file of AsyncRollWriter. Logs are appended to it as length prefixed records while
+// the queue is full, and written back in order once the queue is drained. The logs left by last run are
+// written back on start.
+type spill struct {
+    f *os.File
+    maxSize int64
+    // active is whether any logs are spilled and not written back yet, all the new logs are spilled then
+    // in order to keep the order
+    active int32
+    // readOff is the offset of next log to write back, only accessed by the writing goroutine
+    readOff int64
+
+    mu sync.Mutex
+    size int64
+    buf []byte
+}
+
+// openSpill opens the spill file at path with the mode and owner of log files, keeping the logs left by last run
+func openSpill(path string, maxSize int64, mode os.FileMode, uid, gid int) (*spill, error) {
+    perm := mode
+    if perm == 0 {
+        perm = 0666
+    }
+    f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, perm)
+    if err != nil {
+        return nil, err
+    }
+    applyPerm(path, mode, uid, gid)
+    fi, err := f.Stat()
+    if err != nil {
+        _ = f.Close()
+        return nil, err
+    }
+    // cut the partial record left by a crash, so that new records are appended after complete ones
+    size := completeSize(f, fi.Size())
+    if size < fi.Size() {
+        _ = f.Truncate(size)
+    }
+    s := &spill{f: f, maxSize: maxSize, size: size}
+    if s.size > 0 {
+        s.active = 1
+    }
+    return s, nil
+}
+
+// completeSize returns the size of complete records in the spill file
+func completeSize(f *os.File, size int64) int64 {
+    r := bufio.NewReader(io.NewSectionReader(f, 0, size))
+    var (
+        off int64
+        hdr [4]byte
+    )
+    for {
+        if _, err := io.ReadFull(r, hdr[:]); err != nil {
+            return off
+        }
+        n := int64(binary.BigEndian.Uint32(hdr[:]))
+        if off + 4 + n > size {
+            return off
+        }
+        if _, err := r.Discard(int(n)); err != nil {
+            return off
+        }
+        off += 4 + n
+    }
+}
+
+// isActive returns whether new logs should be spilled to keep the order
+func (s *spill) isActive() bool {
+    return atomic.LoadInt32(&s.active) == 1
+}
+
+// write appends a log, returns false if the spill file is full or fails to write
+func (s *spill) write(data []byte) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.maxSize > 0 && s.size + int64(len(data)) + 4 > s.maxSize {
+        return false
+    }
+    s.buf = append(s.buf[:0], 0, 0, 0, 0)
+    binary.BigEndian.PutUint32(s.buf, uint32(len(data)))
+    s.buf = append(s.buf, data...)
+    // write at the end of last complete record, so that a partial write is overwritten by the next one
+    n, err := s.f.WriteAt(s.buf, s.size)
+    if err != nil {
+        return false
+    }
+    s.size += int64(n)
+    atomic.StoreInt32(&s.active, 1)
+    return true
+}
+
+// backlog returns the size of logs not written back yet
+func (s *spill) backlog() int64 {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.size - atomic.LoadInt64(&s.readOff)
+}
+
+// close closes the spill file, and removes it if all the logs are written back
+func (s *spill) close() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    err := s.f.Close()
+    if s.size == 0 {
+        _ = os.Remove(s.f.Name())
+    }
+    return err
+}
+
+// replaySpill writes back the logs spilled before it is called, and truncates the spill file if all the logs
+// are written back. It should be called when the queues are drained, so that the spilled logs are written
+// after the queued ones
+func (w *AsyncRollWriter) replaySpill() {
+    s := w.spill
+    if s == nil || !s.isActive() {
+        return
+    }
+    s.mu.Lock()
+    end := s.size
+    s.mu.Unlock()
+    off := atomic.LoadInt64(&s.readOff)
+    r := bufio.NewReaderSize(io.NewSectionReader(s.f, off, end - off), 64 * 1024)
+    b := getSlab()
+    var hdr [4]byte
+    for off < end {
+        if _, err := io.ReadFull(r, hdr[:]); err != nil {
+            // drop the partial record left by a crash
+            off = end
+            break
+        }
+        n := int(binary.BigEndian.Uint32(hdr[:]))
+        if int64(n) > end - off - 4 {
+            off = end
+            break
+        }
+        start := len(b.buf)
+        if cap(b.buf) < start + n {
+            buf := make([]byte, start, 2 * (start + n))
+            copy(buf, b.buf)
+            b.buf = buf
+        }
+        b.buf = b.buf[:start + n]
+        if _, err := io.ReadFull(r, b.buf[start:]); err != nil {
+            b.buf = b.buf[:start]
+            off = end
+            break
+        }
+        b.ends = append(b.ends, len(b.buf))
+        off += int64(n) + 4
+        if len(b.buf) >= w.opts.WriteLogSize {
+            w.writeSlab(b)
+        }
+    }
+    w.writeSlab(b)
+    putSlab(b)
+    atomic.StoreInt64(&s.readOff, off)
+    // stop spilling if no more logs are spilled meanwhile
+    s.mu.Lock()
+    if off >= s.size {
+        _ = s.f.Truncate(0)
+        s.size = 0
+        atomic.StoreInt64(&s.readOff, 0)
+        atomic.StoreInt32(&s.active, 0)
+    }
+    s.mu.Unlock()
+}
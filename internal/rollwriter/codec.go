@@ -0,0 +1,187 @@
+package rollwriter
+
+import (
+    "compress/gzip"
+    "io"
+    "io/ioutil"
+    "strings"
+
+    "github.com/klauspost/compress/s2"
+    "github.com/klauspost/compress/zstd"
+    "github.com/pierrec/lz4/v4"
+)
+
+// compressExts is all the extensions of compressed log files, used to recognize them on scavenging
+var compressExts = []string{compressSuffix, zstdSuffix, lz4Suffix, snappySuffix}
+
+const (
+    zstdSuffix   = ".zst"
+    lz4Suffix    = ".lz4"
+    snappySuffix = ".sz"
+)
+
+// Codec is the compression algorithm of rolled log files
+type Codec interface {
+    // Ext returns the extension of compressed file, like ".gz"
+    Ext() string
+    // NewWriter returns a writer which compresses data into w. The writer must be closed to flush data.
+    NewWriter(w io.Writer) (io.WriteCloser, error)
+    // NewReader returns a reader which decompresses data from r, used to verify compressed files.
+    NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// gzipCodec compresses files by gzip
+type gzipCodec struct {
+    level int
+}
+
+// NewGzipCodec creates a gzip Codec, level is between 1(best speed) and 9(best compression), 0 means default
+func NewGzipCodec(level int) Codec {
+    if level == 0 {
+        level = gzip.DefaultCompression
+    }
+    return &gzipCodec{level: level}
+}
+
+// Ext returns the extension of gzip file
+func (c *gzipCodec) Ext() string {
+    return compressSuffix
+}
+
+// NewWriter returns a gzip writer
+func (c *gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+    return gzip.NewWriterLevel(w, c.level)
+}
+
+// NewReader returns a gzip reader
+func (c *gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+    return gzip.NewReader(r)
+}
+
+// zstdCodec compresses files by zstd
+type zstdCodec struct {
+    level int
+}
+
+// NewZstdCodec creates a zstd Codec, level is the zstd compression level like 1-22, 0 means default
+func NewZstdCodec(level int) Codec {
+    return &zstdCodec{level: level}
+}
+
+// Ext returns the extension of zstd file
+func (c *zstdCodec) Ext() string {
+    return zstdSuffix
+}
+
+// NewWriter returns a zstd writer
+func (c *zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+    level := zstd.SpeedDefault
+    if c.level > 0 {
+        level = zstd.EncoderLevelFromZstd(c.level)
+    }
+    return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+}
+
+// NewReader returns a zstd reader
+func (c *zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+    d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+    if err != nil {
+        return nil, err
+    }
+    return d.IOReadCloser(), nil
+}
+
+// lz4Levels maps level 1-9 to lz4 compression levels
+var lz4Levels = []lz4.CompressionLevel{
+    lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// lz4Codec compresses files by lz4
+type lz4Codec struct {
+    level int
+}
+
+// NewLz4Codec creates a lz4 Codec, level is between 1 and 9, 0 means fast
+func NewLz4Codec(level int) Codec {
+    return &lz4Codec{level: level}
+}
+
+// Ext returns the extension of lz4 file
+func (c *lz4Codec) Ext() string {
+    return lz4Suffix
+}
+
+// NewWriter returns a lz4 writer
+func (c *lz4Codec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+    lw := lz4.NewWriter(w)
+    if c.level > 0 && c.level < len(lz4Levels) {
+        if err := lw.Apply(lz4.CompressionLevelOption(lz4Levels[c.level])); err != nil {
+            return nil, err
+        }
+    }
+    return lw, nil
+}
+
+// NewReader returns a lz4 reader
+func (c *lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+    return ioutil.NopCloser(lz4.NewReader(r)), nil
+}
+
+// snappyCodec compresses files in snappy framing format
+type snappyCodec struct{}
+
+// NewSnappyCodec creates a snappy Codec
+func NewSnappyCodec() Codec {
+    return &snappyCodec{}
+}
+
+// Ext returns the extension of snappy file
+func (c *snappyCodec) Ext() string {
+    return snappySuffix
+}
+
+// NewWriter returns a snappy writer
+func (c *snappyCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+    return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1)), nil
+}
+
+// NewReader returns a snappy reader
+func (c *snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+    return ioutil.NopCloser(s2.NewReader(r)), nil
+}
+
+// codecByExt returns the codec which compressed the file by its extension, nil if it is not compressed
+func codecByExt(name string) Codec {
+    switch {
+    case strings.HasSuffix(name, compressSuffix):
+        return NewGzipCodec(0)
+    case strings.HasSuffix(name, zstdSuffix):
+        return NewZstdCodec(0)
+    case strings.HasSuffix(name, lz4Suffix):
+        return NewLz4Codec(0)
+    case strings.HasSuffix(name, snappySuffix):
+        return NewSnappyCodec()
+    default:
+        return nil
+    }
+}
+
+// hasCompressExt checks whether the file is compressed by any codec
+func hasCompressExt(name string) bool {
+    for _, ext := range compressExts {
+        if strings.HasSuffix(name, ext) {
+            return true
+        }
+    }
+    return false
+}
+
+// trimCompressExt removes the extension of compressed file
+func trimCompressExt(name string) string {
+    for _, ext := range compressExts {
+        if strings.HasSuffix(name, ext) {
+            return strings.TrimSuffix(name, ext)
+        }
+    }
+    return name
+}
@@ -1,22 +1,32 @@
 package rollwriter
 
 import (
-    "compress/gzip"
+    "errors"
     "fmt"
     "io"
+    "io/ioutil"
     "os"
+    "path/filepath"
+    "strconv"
     "strings"
+    "sync"
     "time"
 )
 
 const (
-    backupTimeFormat = "bk-20060102-150405.00000"
-    compressSuffix   = ".gz"
+    backupTimeFormat     = "bk-20060102-150405.00000"
+    compressSuffix       = ".gz"
+    compressTmpSuffix    = ".tmp"
+    defaultArchiveLayout = "%Y/%m/%d"
 )
 
-// logInfo is an assistant struct which is used to return file name and last modified time
+// logInfo is an assistant struct which is used to return file path and last modified time
 type logInfo struct {
+    // timestamp is the end of the period which the file holds logs of, used to order and expire files
     timestamp time.Time
+    // start is the start of the period, used to archive the file by date
+    start time.Time
+    path string
     os.FileInfo
 }
 
@@ -46,7 +56,7 @@ func filterByMaxBackups(files []logInfo, remove *[]logInfo, maxBackups int) []lo
     var remaining []logInfo
     preserved := make(map[string]bool)
     for _, f := range files {
-        fn := strings.TrimSuffix(f.Name(), compressSuffix)
+        fn := trimCompressExt(f.Name())
         preserved[fn] = true
 
         if len(preserved) > maxBackups {
@@ -58,7 +68,7 @@ func filterByMaxBackups(files []logInfo, remove *[]logInfo, maxBackups int) []lo
     return remaining
 }
 
-// filterByMaxAge filters expired files
+// filterByMaxAge filters expired files by rolling time
 func filterByMaxAge(files []logInfo, remove *[]logInfo, maxAge int) []logInfo {
     if maxAge <= 0 {
         return files
@@ -82,33 +92,305 @@ func filterByCompressExt(files []logInfo, compress *[]logInfo, needCompress bool
         return
     }
     for _, f := range files {
-        if !strings.HasSuffix(f.Name(), compressSuffix) {
+        if !hasCompressExt(f.Name()) {
             *compress = append(*compress, f)
         }
     }
 }
 
-// compressFile compress file src to dst, and removes src on success
-func compressFile(src, dst string) error {
+// parsePendingBackup checks whether name is a backup waiting to be numbered, like a.log.bk-20221016-150405.00000,
+// if matched, returns the rolling time
+func parsePendingBackup(name string) (time.Time, bool) {
+    n := len(name) - len(backupTimeFormat)
+    if n < 2 || name[n-1] != '.' {
+        return time.Time{}, false
+    }
+    t, err := time.ParseInLocation(backupTimeFormat, name[n:], time.Local)
+    if err != nil {
+        return time.Time{}, false
+    }
+    return t, true
+}
+
+// uniqueBackupPath returns path with ext, or adds a suffix like -1 to path if it or any other backup of the same
+// name exists, so that backups do not overwrite each other. The suffix goes after the largest existing one
+// to keep the names ordered by rolling time even if some of them were removed.
+func uniqueBackupPath(path, ext string) string {
+    files, err := ioutil.ReadDir(filepath.Dir(path))
+    if err != nil {
+        return path + ext
+    }
+    base, exists, last := filepath.Base(path), false, 0
+    for _, f := range files {
+        name := trimCompressExt(f.Name())
+        if name == base {
+            exists = true
+            continue
+        }
+        if !strings.HasPrefix(name, base+"-") {
+            continue
+        }
+        if n, err := strconv.Atoi(name[len(base)+1:]); err == nil && n > last {
+            exists, last = true, n
+        }
+    }
+    if !exists {
+        return path + ext
+    }
+    return path + "-" + strconv.Itoa(last+1) + ext
+}
+
+// parseNumberedBackup checks whether name is a numbered backup of logName, like a.log.1 or a.log.2.gz,
+// if matched, returns the number
+func parseNumberedBackup(name, logName string) (int, bool) {
+    if !strings.HasPrefix(name, logName+".") {
+        return 0, false
+    }
+    num := trimCompressExt(name[len(logName)+1:])
+    n, err := strconv.Atoi(num)
+    if err != nil || n <= 0 {
+        return 0, false
+    }
+    return n, true
+}
+
+// strftimeLayouts maps the strftime verbs to the Go time layout
+var strftimeLayouts = map[byte]string{
+    'Y': "2006",
+    'y': "06",
+    'm': "01",
+    'd': "02",
+    'H': "15",
+    'M': "04",
+    'S': "05",
+    '%': "%",
+}
+
+// strftimeLayout converts strftime pattern like .%Y%m%d to the Go time layout, only numeric verbs are supported
+func strftimeLayout(pattern string) (string, bool) {
+    var b strings.Builder
+    for i := 0; i < len(pattern); i++ {
+        if pattern[i] != '%' {
+            b.WriteByte(pattern[i])
+            continue
+        }
+        i++
+        if i >= len(pattern) {
+            return "", false
+        }
+        layout, ok := strftimeLayouts[pattern[i]]
+        if !ok {
+            return "", false
+        }
+        b.WriteString(layout)
+    }
+    return b.String(), true
+}
+
+// moveFile moves file src to dst, copies it when they are on different devices
+func moveFile(src, dst string) error {
+    if err := os.Rename(src, dst); err == nil {
+        return nil
+    }
+    st, err := os.Stat(src)
+    if err != nil {
+        return err
+    }
+    f, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    df, err := os.OpenFile(dst, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, st.Mode())
+    if err != nil {
+        return err
+    }
+    if _, err = io.Copy(df, f); err != nil {
+        _ = df.Close()
+        _ = os.Remove(dst)
+        return err
+    }
+    if err = df.Close(); err != nil {
+        _ = os.Remove(dst)
+        return err
+    }
+    // keep the modified time which the scavenger relies on
+    _ = os.Chtimes(dst, st.ModTime(), st.ModTime())
+    return os.Remove(src)
+}
+
+// errStopped is returned when compressing is stopped by closing the writer
+var errStopped = errors.New("compressing is stopped")
+
+// compressFile compress file src to dst by codec, and removes src on success.
+// It compresses into a temporary file, which is synced, verified and renamed to dst at last,
+// so that a crash never leaves a truncated dst. It gives up and removes the temporary file once stop is closed,
+// leaving src to be compressed again after restarting.
+func compressFile(src, dst string, codec Codec, limiter *rateLimiter, mode os.FileMode, stop <-chan struct{}) error {
     f, err := os.Open(src)
     if err != nil {
         return fmt.Errorf("failed to open file: %v", err)
     }
     defer f.Close()
+    st, err := f.Stat()
+    if err != nil {
+        return fmt.Errorf("failed to stat file: %v", err)
+    }
 
-    gzf, err := os.OpenFile(dst, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, 0666)
+    tmp := dst + compressTmpSuffix
+    cf, err := os.OpenFile(tmp, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, mode)
     if err != nil {
         return fmt.Errorf("failed to open compressed file: %v", err)
     }
-    defer gzf.Close()
-
-    gz := gzip.NewWriter(gzf)
-    defer gz.Close()
+    if err = writeCompressed(cf, f, codec, limiter, stop); err != nil {
+        _ = cf.Close()
+        _ = os.Remove(tmp)
+        return err
+    }
+    if err = cf.Close(); err != nil {
+        _ = os.Remove(tmp)
+        return fmt.Errorf("failed to close compressed file: %v", err)
+    }
+    if err = verifyCompressed(tmp, codec, st.Size()); err != nil {
+        _ = os.Remove(tmp)
+        return err
+    }
+    if err = os.Rename(tmp, dst); err != nil {
+        _ = os.Remove(tmp)
+        return fmt.Errorf("failed to rename compressed file: %v", err)
+    }
+    syncDir(filepath.Dir(dst))
+    _ = os.Remove(src)
+    return nil
+}
 
-    if _, err = io.Copy(gz, f); err != nil {
-        _ = os.Remove(dst)
+// writeCompressed compresses r into f by codec and syncs f to disk
+func writeCompressed(f *os.File, r io.Reader, codec Codec, limiter *rateLimiter, stop <-chan struct{}) error {
+    cw, err := codec.NewWriter(f)
+    if err != nil {
+        return fmt.Errorf("failed to create compressor: %v", err)
+    }
+    if _, err = io.Copy(cw, &limitedReader{r: r, limiter: limiter, stop: stop}); err != nil {
+        _ = cw.Close()
+        if err == errStopped {
+            return err
+        }
         return fmt.Errorf("fialed to compress file: %v", err)
     }
-    _ = os.Remove(src)
+    if err = cw.Close(); err != nil {
+        return fmt.Errorf("failed to flush compressed file: %v", err)
+    }
+    if err = f.Sync(); err != nil {
+        return fmt.Errorf("failed to sync compressed file: %v", err)
+    }
     return nil
-}
\ No newline at end of file
+}
+
+// verifyCompressed checks whether the compressed file can be decompressed to size bytes
+func verifyCompressed(path string, codec Codec, size int64) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("failed to open compressed file: %v", err)
+    }
+    defer f.Close()
+    cr, err := codec.NewReader(f)
+    if err != nil {
+        return fmt.Errorf("failed to verify compressed file: %v", err)
+    }
+    defer cr.Close()
+    n, err := io.Copy(ioutil.Discard, cr)
+    if err != nil {
+        return fmt.Errorf("failed to verify compressed file: %v", err)
+    }
+    if n != size {
+        return fmt.Errorf("failed to verify compressed file: size %d, expected %d", n, size)
+    }
+    return nil
+}
+
+// syncDir syncs the directory entries to disk after renaming, errors are ignored as not all platforms support it
+func syncDir(dir string) {
+    d, err := os.Open(dir)
+    if err != nil {
+        return
+    }
+    _ = d.Sync()
+    _ = d.Close()
+}
+
+// rateLimiter limits the throughput in bytes per second, shared by all compressing workers
+type rateLimiter struct {
+    mu   sync.Mutex
+    rate int64
+    next time.Time
+}
+
+// newRateLimiter creates a rateLimiter, returns nil which means no limit if rate <= 0
+func newRateLimiter(rate int64) *rateLimiter {
+    if rate <= 0 {
+        return nil
+    }
+    return &rateLimiter{rate: rate}
+}
+
+// wait blocks until n bytes are allowed, returns false if stop is closed before that
+func (l *rateLimiter) wait(n int, stop <-chan struct{}) bool {
+    if l == nil || n <= 0 {
+        return true
+    }
+    l.mu.Lock()
+    now := time.Now()
+    if l.next.Before(now) {
+        l.next = now
+    }
+    l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+    d := l.next.Sub(now)
+    l.mu.Unlock()
+    if d <= 0 {
+        return true
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return true
+    case <-stop:
+        return false
+    }
+}
+
+// limitedReader is a reader limited by rateLimiter, which fails with errStopped once stop is closed
+type limitedReader struct {
+    r       io.Reader
+    limiter *rateLimiter
+    stop    <-chan struct{}
+}
+
+// Read reads at most 32KB every time. It implements io.Reader
+func (r *limitedReader) Read(p []byte) (int, error) {
+    select {
+    case <-r.stop:
+        return 0, errStopped
+    default:
+    }
+    if r.limiter != nil && len(p) > 32 * 1024 {
+        p = p[:32 * 1024]
+    }
+    n, err := r.r.Read(p)
+    if !r.limiter.wait(n, r.stop) {
+        return 0, errStopped
+    }
+    return n, err
+}
+
+// consumeBuffers removes the first n bytes from bufs
+func consumeBuffers(bufs [][]byte, n int) [][]byte {
+    for len(bufs) > 0 && n >= len(bufs[0]) {
+        n -= len(bufs[0])
+        bufs = bufs[1:]
+    }
+    if len(bufs) > 0 {
+        bufs[0] = bufs[0][n:]
+    }
+    return bufs
+}
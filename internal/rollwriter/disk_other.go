@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package rollwriter
+
+// freeDiskSpace returns -1 as the available bytes are unknown on this platform
+func freeDiskSpace(dir string) int64 {
+    return -1
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package rollwriter
+
+import "syscall"
+
+// freeDiskSpace returns the available bytes of the file system which dir is on, -1 if unknown
+func freeDiskSpace(dir string) int64 {
+    var st syscall.Statfs_t
+    if err := syscall.Statfs(dir, &st); err != nil {
+        return -1
+    }
+    return int64(st.Bavail) * int64(st.Bsize)
+}
@@ -0,0 +1,81 @@
+package rollwriter
+
+import (
+    "sync/atomic"
+    "time"
+)
+
+// EventType is the type of file event of RollWriter
+type EventType int
+
+const (
+    // EventOpened is emitted when a new log file is opened
+    EventOpened EventType = 1
+    // EventRotated is emitted when the current log file is finished by rolling
+    EventRotated EventType = 2
+    // EventCompressed is emitted when a log file is compressed
+    EventCompressed EventType = 3
+    // EventDeleted is emitted when a log file is deleted by scavenger
+    EventDeleted EventType = 4
+    // EventArchived is emitted when a log file is moved into the archive directory
+    EventArchived EventType = 5
+)
+
+// Event is the file event of RollWriter
+type Event struct {
+    // Type is the event type
+    Type EventType
+    // Path is the file path. On rotated it is where the finished file is, empty if the file is moved or deleted
+    // externally. On compressed it is the compressed file, on archived it is the file in archive directory
+    Path string
+    // OldPath is the former file path on rotated, compressed or archived, empty if the finished file is not
+    // moved on rotated by time, and for other events
+    OldPath string
+    // CurrPath is the current log file after rotated, empty for other events
+    CurrPath string
+    // Time is when the event happens
+    Time time.Time
+}
+
+// EventHandler handles events of RollWriter. It runs in a separate goroutine in order of events, the events
+// are dropped if it falls behind by more than eventQueueSize
+type EventHandler func(e Event)
+
+// eventQueueSize is the number of events waiting for the event handler
+const eventQueueSize = 100
+
+// emit sends an event to the event handler
+func (w *RollWriter) emit(typ EventType, path, oldPath string) {
+    w.send(Event{Type: typ, Path: path, OldPath: oldPath})
+}
+
+// emitRotated sends a rotated event with the current log file to the event handler
+func (w *RollWriter) emitRotated(path, oldPath, currPath string) {
+    w.send(Event{Type: EventRotated, Path: path, OldPath: oldPath, CurrPath: currPath})
+}
+
+// send sends an event without blocking, as it is called on the write path under lock
+func (w *RollWriter) send(e Event) {
+    if w.eventCh == nil {
+        return
+    }
+    e.Time = time.Now()
+    select {
+    case w.eventCh <- e:
+    default:
+        atomic.AddUint64(&w.droppedEvents, 1)
+    }
+}
+
+// DroppedEvents returns the number of events dropped as the event handler falls behind
+func (w *RollWriter) DroppedEvents() uint64 {
+    return atomic.LoadUint64(&w.droppedEvents)
+}
+
+// runEvents calls the event handler in a new goroutine
+func (w *RollWriter) runEvents() {
+    defer w.eventWg.Done()
+    for e := range w.eventCh {
+        w.opts.EventHandler(e)
+    }
+}
@@ -7,6 +7,7 @@ import (
     "io/ioutil"
     "os"
     "sort"
+    "strconv"
     "strings"
     "sync"
     "sync/atomic"
@@ -21,18 +22,37 @@ type RollWriter struct {
     opts *Options
 
     pattern *strftime.Strftime
+    archivePattern *strftime.Strftime
+    limiter *rateLimiter
+    recovered bool
     currDir string
     currPath string
     currSize int64
     currFile atomic.Value
     openTime int64
+    periodEnd int64
+    statTime int64
+    backupSize int64
+    quotaTime int64
+    degraded int32
+    dirty int32
+    closed int32
+    droppedEvents uint64
 
     mu sync.Mutex
     once sync.Once
+    started bool
     notifyCh chan bool
     closeCh chan *os.File
+    eventCh chan Event
+    stopCh chan struct{}
+    wg sync.WaitGroup
+    eventWg sync.WaitGroup
 }
 
+// ErrClosed is returned when writing to a closed writer
+var ErrClosed = errors.New("writer is closed")
+
 // NewRollWriter creates a new RollWriter
 func NewRollWriter(filePath string, opt ...Option) (*RollWriter, error) {
     opts := &Options{
@@ -49,6 +69,18 @@ func NewRollWriter(filePath string, opt ...Option) (*RollWriter, error) {
     if filePath == "" {
         return nil, errors.New("invalid file path")
     }
+    if opts.ArchiveLayout == "" {
+        opts.ArchiveLayout = defaultArchiveLayout
+    }
+    if opts.Codec == nil {
+        opts.Codec = NewGzipCodec(0)
+    }
+    if opts.BackupTimeFormat == "" {
+        opts.BackupTimeFormat = backupTimeFormat
+    }
+    if opts.CompressConcurrency <= 0 {
+        opts.CompressConcurrency = 1
+    }
     patter, err := strftime.New(filePath + opts.TimeFormat)
     if err != nil {
         return nil, errors.New("invalid time pattern")
@@ -57,29 +89,91 @@ func NewRollWriter(filePath string, opt ...Option) (*RollWriter, error) {
         filePath: filePath,
         opts: opts,
         pattern: patter,
+        stopCh: make(chan struct{}),
         currDir: filepath.Dir(filePath),
+        limiter: newRateLimiter(opts.CompressRateLimit),
     }
-    if err := os.MkdirAll(w.currDir, 0755); err != nil {
+    if err := w.mkdirAll(w.currDir); err != nil {
         return nil, err
     }
+    if opts.ArchiveDir != "" {
+        if w.archivePattern, err = strftime.New(opts.ArchiveLayout); err != nil {
+            return nil, errors.New("invalid archive layout")
+        }
+    }
+    if opts.EventHandler != nil {
+        w.eventCh = make(chan Event, eventQueueSize)
+        w.eventWg.Add(1)
+        go w.runEvents()
+    }
+    if opts.FsyncPolicy == FsyncInterval && opts.FsyncInterval > 0 {
+        w.wg.Add(1)
+        go w.runFsync()
+    }
+    // open the current log file at once, which also runs the first retention sweep
+    w.mu.Lock()
+    _ = w.openCurrFile()
+    w.mu.Unlock()
+    if opts.CleanInterval > 0 && w.needClean() {
+        w.wg.Add(1)
+        go w.runSweep()
+    }
     return w, nil
 }
 
 // Write writes logs. It implements io.Writer
 func (w *RollWriter) Write(v []byte) (int, error) {
-    // reopen file every 10 seconds
-    if w.getCurrFile() == nil || time.Now().Unix() - atomic.LoadInt64(&w.openTime) > 10 {
+    f, err := w.prepareWrite()
+    if err != nil {
+        return 0, err
+    }
+    n, err := f.Write(v)
+    return n, w.finishWrite(f, n, err)
+}
+
+// WriteBuffers writes the logs in bufs at a time, by one writev system call where supported.
+// It implements BuffersWriter
+func (w *RollWriter) WriteBuffers(bufs [][]byte) (int, error) {
+    f, err := w.prepareWrite()
+    if err != nil {
+        return 0, err
+    }
+    n, err := writeBuffers(f, bufs)
+    return n, w.finishWrite(f, n, err)
+}
+
+// prepareWrite reopens or checks the current log file before writing, and returns it
+func (w *RollWriter) prepareWrite() (*os.File, error) {
+    if atomic.LoadInt32(&w.closed) == 1 {
+        return nil, ErrClosed
+    }
+    // reopen file every 10 seconds or at the end of rolling period
+    if w.needReopen() {
         w.mu.Lock()
         w.reopenFile()
         w.mu.Unlock()
+    } else if w.opts.StatInterval > 0 {
+        w.statFile()
     }
     // return when failed to open the file
-    if w.getCurrFile() == nil {
-        return 0, errors.New("open file fail")
+    f := w.getCurrFile()
+    if f == nil {
+        return nil, errors.New("open file fail")
     }
-    // write logs to file
-    n, err := w.getCurrFile().Write(v)
+    return f, nil
+}
+
+// finishWrite commits the written logs by the fsync policy, and rolls the file on full
+func (w *RollWriter) finishWrite(f *os.File, n int, err error) error {
     atomic.AddInt64(&w.currSize, int64(n))
+    switch w.opts.FsyncPolicy {
+    case FsyncAlways:
+        if err == nil {
+            err = f.Sync()
+        }
+    case FsyncInterval:
+        atomic.StoreInt32(&w.dirty, 1)
+    }
 
     // rolling on full
     if w.opts.MaxSize > 0 && atomic.LoadInt64(&w.currSize) >= w.opts.MaxSize {
@@ -87,52 +181,287 @@ func (w *RollWriter) Write(v []byte) (int, error) {
         w.backupFile()
         w.mu.Unlock()
     }
-    return n, err
+    // check disk quota every second
+    if w.opts.MaxTotalSize > 0 || w.opts.MinFreeDisk > 0 {
+        w.checkQuota()
+    }
+    return err
+}
+
+// Sync commits the current log file to disk unless the fsync policy is FsyncNever.
+// It implements zapcore.WriteSyncer
+func (w *RollWriter) Sync() error {
+    if w.opts.FsyncPolicy == FsyncNever {
+        return nil
+    }
+    f := w.getCurrFile()
+    if f == nil {
+        return nil
+    }
+    atomic.StoreInt32(&w.dirty, 0)
+    return f.Sync()
+}
+
+// runFsync commits the current log file to disk every FsyncInterval in a new goroutine
+func (w *RollWriter) runFsync() {
+    defer w.wg.Done()
+    ticker := time.NewTicker(w.opts.FsyncInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            if !atomic.CompareAndSwapInt32(&w.dirty, 1, 0) {
+                continue
+            }
+            if f := w.getCurrFile(); f != nil {
+                _ = f.Sync()
+            }
+        case <-w.stopCh:
+            return
+        }
+    }
+}
+
+// runSweep notifies the scavenger every CleanInterval, independent of rotation
+func (w *RollWriter) runSweep() {
+    defer w.wg.Done()
+    ticker := time.NewTicker(w.opts.CleanInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            w.mu.Lock()
+            w.notify()
+            w.mu.Unlock()
+        case <-w.stopCh:
+            return
+        }
+    }
+}
+
+// Degraded returns whether the disk quota is exceeded even after all backups are removed.
+// Only important logs should be written in degraded mode.
+func (w *RollWriter) Degraded() bool {
+    return atomic.LoadInt32(&w.degraded) == 1
+}
+
+// checkQuota notifies the scavenger when the total size or free disk space exceeds the limit
+func (w *RollWriter) checkQuota() {
+    now := time.Now().Unix()
+    last := atomic.LoadInt64(&w.quotaTime)
+    if now == last || !atomic.CompareAndSwapInt64(&w.quotaTime, last, now) {
+        return
+    }
+    if w.overQuota(atomic.LoadInt64(&w.backupSize), 0) {
+        w.mu.Lock()
+        w.notify()
+        w.mu.Unlock()
+    } else {
+        atomic.StoreInt32(&w.degraded, 0)
+    }
+}
+
+// overQuota checks whether the total size of log files or the free disk space exceeds the limit,
+// assuming the backups are of backups bytes after freed bytes are removed.
+func (w *RollWriter) overQuota(backups, freed int64) bool {
+    total := atomic.LoadInt64(&w.currSize) + backups
+    if w.opts.MaxTotalSize > 0 && total > w.opts.MaxTotalSize {
+        return true
+    }
+    if w.opts.MinFreeDisk > 0 {
+        if free := freeDiskSpace(w.currDir); free >= 0 && free+freed < w.opts.MinFreeDisk {
+            return true
+        }
+    }
+    return false
 }
 
 // Close close the current log file. It implements io.Closer
+// Later writes return ErrClosed. It stops all the goroutines after the running scavenging and
+// the pending events are finished. Running compression is given up and redone after restarting.
 func (w *RollWriter) Close() error {
-    if w.getCurrFile() == nil {
+    w.mu.Lock()
+    if atomic.LoadInt32(&w.closed) == 1 {
+        w.mu.Unlock()
         return nil
     }
-    err := w.getCurrFile().Close()
-    w.setCurrFile(nil)
+    atomic.StoreInt32(&w.closed, 1)
+    var err error
+    if f := w.getCurrFile(); f != nil {
+        if w.opts.FsyncPolicy != FsyncNever {
+            err = f.Sync()
+        }
+        if e := f.Close(); e != nil && err == nil {
+            err = e
+        }
+        w.setCurrFile(nil)
+    }
+    if w.started {
+        close(w.notifyCh)
+        close(w.closeCh)
+    }
+    close(w.stopCh)
+    w.mu.Unlock()
+
+    w.wg.Wait()
+    if w.eventCh != nil {
+        close(w.eventCh)
+        w.eventWg.Wait()
+    }
     return err
 }
 
 // reopenFile reopen the file regularly. It notifies the scavenger if file path has changed
 func (w *RollWriter) reopenFile() {
-    if w.getCurrFile() == nil || time.Now().Unix() - atomic.LoadInt64(&w.openTime) > 10 {
-        atomic.StoreInt64(&w.openTime, time.Now().Unix())
-        currPath := w.pattern.FormatString(time.Now())
-        if w.currPath != currPath {
-            w.currPath = currPath
-            w.notify()
+    if atomic.LoadInt32(&w.closed) == 0 && w.needReopen() {
+        _ = w.openCurrFile()
+    }
+}
+
+// needReopen checks whether the file should be reopened, every 10 seconds or at the end of rolling period
+func (w *RollWriter) needReopen() bool {
+    if w.getCurrFile() == nil {
+        return true
+    }
+    now := time.Now()
+    if end := atomic.LoadInt64(&w.periodEnd); end > 0 && now.UnixNano() >= end {
+        return true
+    }
+    return now.Unix() - atomic.LoadInt64(&w.openTime) > 10
+}
+
+// openCurrFile updates the current file path by time and reopens it
+func (w *RollWriter) openCurrFile() error {
+    now := time.Now()
+    atomic.StoreInt64(&w.openTime, now.Unix())
+    if w.opts.Location != nil {
+        now = now.In(w.opts.Location)
+    }
+    // name the file by the start of rolling period
+    if w.opts.RotationPeriod != nil {
+        start, end := w.opts.RotationPeriod(now)
+        atomic.StoreInt64(&w.periodEnd, end.UnixNano())
+        now = start
+    }
+    currPath := w.pattern.FormatString(now)
+    if w.currPath != currPath {
+        // the file of last time period is finished
+        oldPath := w.currPath
+        w.currPath = currPath
+        if oldPath != "" {
+            w.emitRotated(oldPath, "", currPath)
+        }
+        w.notify()
+        return w.doReopenFile(w.currPath)
+    }
+    return w.checkFile()
+}
+
+// statFile checks the current log file every StatInterval
+func (w *RollWriter) statFile() {
+    now := time.Now().UnixNano()
+    last := atomic.LoadInt64(&w.statTime)
+    if now - last < int64(w.opts.StatInterval) || !atomic.CompareAndSwapInt64(&w.statTime, last, now) {
+        return
+    }
+    w.mu.Lock()
+    if atomic.LoadInt32(&w.closed) == 0 {
+        _ = w.checkFile()
+    }
+    w.mu.Unlock()
+}
+
+// checkFile reopens the current log file if it is deleted or replaced externally by comparing the inode,
+// otherwise syncs the size of it
+func (w *RollWriter) checkFile() error {
+    f := w.getCurrFile()
+    if f == nil {
+        return w.doReopenFile(w.currPath)
+    }
+    if st, err := os.Stat(w.currPath); err == nil {
+        if fst, err := f.Stat(); err == nil && os.SameFile(st, fst) {
+            atomic.StoreInt64(&w.currSize, st.Size())
+            return nil
+        }
+    }
+    // the file is moved away or deleted, the new path is unknown
+    w.emitRotated("", w.currPath, w.currPath)
+    return w.doReopenFile(w.currPath)
+}
+
+// Reopen reopens the current log file immediately, e.g. after it is moved by external logrotate
+func (w *RollWriter) Reopen() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if atomic.LoadInt32(&w.closed) == 1 {
+        return ErrClosed
+    }
+    return w.openCurrFile()
+}
+
+// Rotate backs the current log file up and opens a new one, regardless of its size
+func (w *RollWriter) Rotate() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if atomic.LoadInt32(&w.closed) == 1 {
+        return ErrClosed
+    }
+    if w.getCurrFile() == nil {
+        if err := w.openCurrFile(); err != nil {
+            return err
         }
-        _ = w.doReopenFile(w.currPath)
     }
+    return w.rotateFile()
 }
 
 // backupFile backs this file up and reopen a new one if file size is too large
 func (w *RollWriter) backupFile() {
-    if w.opts.MaxSize > 0 && atomic.LoadInt64(&w.currSize) >= w.opts.MaxSize {
-        atomic.StoreInt64(&w.currSize, 0)
-        // rename the old file
-        NewName := w.currPath + "." + time.Now().Format(backupTimeFormat)
-        if _, e := os.Stat(w.currPath); !os.IsNotExist(e) {
-            _ = os.Rename(w.currPath, NewName)
-        }
-        // reopen a new one
-        _ = w.doReopenFile(w.currPath)
-        w.notify()
+    if atomic.LoadInt32(&w.closed) == 0 && w.opts.MaxSize > 0 && atomic.LoadInt64(&w.currSize) >= w.opts.MaxSize {
+        _ = w.rotateFile()
+    }
+}
+
+// rotateFile renames the current log file to backup name and reopens a new one
+func (w *RollWriter) rotateFile() error {
+    atomic.StoreInt64(&w.currSize, 0)
+    // rename the old file
+    if _, e := os.Stat(w.currPath); !os.IsNotExist(e) {
+        name := w.backupName()
+        // numbered backups emit the event after numbering
+        if err := os.Rename(w.currPath, name); err == nil && !w.opts.NumberedBackup {
+            w.emitRotated(name, w.currPath, w.currPath)
+        }
     }
+    // reopen a new one
+    err := w.doReopenFile(w.currPath)
+    w.notify()
+    return err
 }
 
-// notify runs scavengers
+// backupName returns the name which current log file is renamed to on rolling.
+// Numbered backups are renamed to a pending name first, and numbered later by the scavenger.
+func (w *RollWriter) backupName() string {
+    t := time.Now()
+    if w.opts.NumberedBackup {
+        return w.currPath + "." + t.Format(backupTimeFormat)
+    }
+    if w.opts.BackupUTC {
+        t = t.UTC()
+    }
+    // a coarse layout like "20060102" gives the same name to the backups in a period
+    return uniqueBackupPath(w.currPath + "." + t.Format(w.opts.BackupTimeFormat), "")
+}
+
+// notify runs scavengers. It must be called with w.mu held
 func (w *RollWriter) notify() {
+    if atomic.LoadInt32(&w.closed) == 1 {
+        return
+    }
     w.once.Do(func() {
+        w.started = true
         w.notifyCh = make(chan bool, 1)
         w.closeCh = make(chan *os.File, 100)
+        w.wg.Add(2)
         go w.runCleanFiles()
         go w.runCloseFiles()
     })
@@ -144,6 +473,7 @@ func (w *RollWriter) notify() {
 
 // runCloseFiles delay closing file in a new goroutine
 func (w *RollWriter) runCloseFiles() {
+    defer w.wg.Done()
     for f := range w.closeCh {
         // 延迟 20ms 关闭
         time.Sleep(20*time.Millisecond)
@@ -153,88 +483,436 @@ func (w *RollWriter) runCloseFiles() {
 
 // runCleanFiles cleans redundant or expired (compressed) logs in a new goroutine
 func (w *RollWriter) runCleanFiles() {
+    defer w.wg.Done()
     for range w.notifyCh {
-        if w.opts.MaxBackups == 0 && w.opts.MaxAge == 0 && !w.opts.Compress {
+        if !w.needClean() {
             continue
         }
         w.cleanFiles()
     }
 }
 
+// needClean checks whether any option needs the scavenger
+func (w *RollWriter) needClean() bool {
+    return w.opts.MaxBackups > 0 || w.opts.MaxAge > 0 || w.opts.Compress || w.opts.NumberedBackup ||
+        w.opts.ArchiveDir != "" || w.opts.MaxTotalSize > 0 || w.opts.MinFreeDisk > 0
+}
+
 // cleanFiles cleans redundant or expired (compressed) logs
 func (w *RollWriter) cleanFiles() {
-    // get the file list of current log
-    files, err := w.getOldLogFiles()
-    if err != nil || len(files) == 0 {
+    // resume the compressing interrupted by last crash
+    if !w.recovered {
+        w.recovered = true
+        w.recoverCompress()
+    }
+    // number the pending backups before scavenging
+    if w.opts.NumberedBackup {
+        w.numberBackups()
+    } else if w.opts.ArchiveDir != "" {
+        // move the finished files out of the live directory
+        w.archiveFiles()
+    }
+    compress, remove, over, err := w.planFiles()
+    if err != nil {
         return
     }
+    // enter degraded mode if the quota can't be met even all the backups are removed
+    if w.opts.MaxTotalSize > 0 || w.opts.MinFreeDisk > 0 {
+        if over {
+            atomic.StoreInt32(&w.degraded, 1)
+        } else {
+            atomic.StoreInt32(&w.degraded, 0)
+        }
+    }
+    // delete expired or redundant
+    w.removeFiles(remove)
+    // compress log files
+    w.compressFile(compress)
+    // record the size of backups for quota checking
+    if w.opts.MaxTotalSize > 0 || w.opts.MinFreeDisk > 0 {
+        w.updateBackupSize()
+    }
+}
+
+// CleanPlan is what the scavenger would do with the old log files
+type CleanPlan struct {
+    // Compress is the files to be compressed
+    Compress []string
+    // Remove is the files to be deleted
+    Remove []string
+}
+
+// Plan returns the files which would be compressed or deleted by the scavenger now, without touching them.
+// Pending numbered backups and files to be archived are planned with their current paths.
+func (w *RollWriter) Plan() (*CleanPlan, error) {
+    compress, remove, _, err := w.planFiles()
+    if err != nil {
+        return nil, err
+    }
+    plan := &CleanPlan{}
+    for _, f := range compress {
+        plan.Compress = append(plan.Compress, f.path)
+    }
+    for _, f := range remove {
+        plan.Remove = append(plan.Remove, f.path)
+    }
+    return plan, nil
+}
 
+// planFiles finds the old log files to compress or remove, and whether the quota is still exceeded after removing
+func (w *RollWriter) planFiles() (compress, remove []logInfo, over bool, err error) {
+    // get the file list of current log
+    files, err := w.getOldLogFiles()
+    if err != nil {
+        return nil, nil, false, err
+    }
     // find the oldest files to scavenge
-    var compress, remove []logInfo
     files = filterByMaxBackups(files, &remove, w.opts.MaxBackups)
-    // find the expired files by last modified time
+    // find the expired files by rolling time
     files = filterByMaxAge(files, &remove, w.opts.MaxAge)
+    // find the oldest files exceeding disk quota
+    files, over = w.filterByQuota(files, &remove)
     // find files to compress by file extension ".gz"
     filterByCompressExt(files, &compress, w.opts.Compress)
-    // delete expired or redundant
-    w.removeFiles(remove)
-    // compress log files
-    w.compressFile(compress)
+    return compress, remove, over, nil
+}
+
+// filterByQuota filters the oldest files until the total size and free disk space meet the limit,
+// returns whether the limit is still exceeded even all the backups are removed
+func (w *RollWriter) filterByQuota(files []logInfo, remove *[]logInfo) ([]logInfo, bool) {
+    if w.opts.MaxTotalSize <= 0 && w.opts.MinFreeDisk <= 0 {
+        return files, false
+    }
+    var size, freed int64
+    for _, f := range files {
+        size += f.Size()
+    }
+    for _, f := range *remove {
+        freed += f.Size()
+    }
+    // files are ordered by time descending, remove from the oldest
+    i := len(files)
+    for i > 0 && w.overQuota(size, freed) {
+        i--
+        *remove = append(*remove, files[i])
+        size -= files[i].Size()
+        freed += files[i].Size()
+    }
+    return files[:i], w.overQuota(size, freed)
+}
+
+// updateBackupSize records the total size of backups
+func (w *RollWriter) updateBackupSize() {
+    files, err := w.getOldLogFiles()
+    if err != nil {
+        return
+    }
+    var size int64
+    for _, f := range files {
+        size += f.Size()
+    }
+    atomic.StoreInt64(&w.backupSize, size)
+}
+
+// numberBackups renames pending backups to numbered ones like logrotate, a.log.1 is the newest.
+// It runs only in the scavenger goroutine, so that shifting never races with compressing or removing.
+func (w *RollWriter) numberBackups() {
+    files, err := ioutil.ReadDir(w.currDir)
+    if err != nil {
+        return
+    }
+    var pending []logInfo
+    prefix := filepath.Base(w.filePath)
+    for _, f := range files {
+        if f.IsDir() || !strings.HasPrefix(f.Name(), prefix) {
+            continue
+        }
+        if t, ok := parsePendingBackup(f.Name()); ok {
+            pending = append(pending, logInfo{timestamp: t, path: filepath.Join(w.currDir, f.Name()), FileInfo: f})
+        }
+    }
+    // number the oldest first, so that the newest one ends up as a.log.1
+    sort.Sort(sort.Reverse(byFormatTime(pending)))
+    for _, f := range pending {
+        logName := f.Name()[:len(f.Name())-len(backupTimeFormat)-1]
+        w.shiftBackups(files, logName)
+        src, dst := filepath.Join(w.currDir, f.Name()), filepath.Join(w.currDir, logName+".1")
+        if err := os.Rename(src, dst); err == nil {
+            currPath := filepath.Join(w.currDir, logName)
+            w.emitRotated(dst, currPath, currPath)
+        }
+        if files, err = ioutil.ReadDir(w.currDir); err != nil {
+            return
+        }
+    }
+}
+
+// shiftBackups shifts the numbered backups of logName by one, a.log.1 -> a.log.2, a.log.2.gz -> a.log.3.gz
+func (w *RollWriter) shiftBackups(files []os.FileInfo, logName string) {
+    maxNum := 0
+    for _, f := range files {
+        if n, ok := parseNumberedBackup(f.Name(), logName); ok && n > maxNum {
+            maxNum = n
+        }
+    }
+    for i := maxNum; i >= 1; i-- {
+        for _, ext := range append([]string{""}, compressExts...) {
+            src := filepath.Join(w.currDir, fmt.Sprintf("%s.%d%s", logName, i, ext))
+            if _, err := os.Stat(src); err != nil {
+                continue
+            }
+            _ = os.Rename(src, filepath.Join(w.currDir, fmt.Sprintf("%s.%d%s", logName, i+1, ext)))
+        }
+    }
+}
+
+// archiveFiles moves the finished log files into the archive directory by layout, like archive/2022/10/16/
+func (w *RollWriter) archiveFiles() {
+    files, err := w.readLogDir(w.currDir)
+    if err != nil {
+        return
+    }
+    for _, f := range files {
+        dir := filepath.Join(w.opts.ArchiveDir, w.archivePattern.FormatString(f.start))
+        if err := w.mkdirAll(dir); err != nil {
+            continue
+        }
+        name := trimCompressExt(f.Name())
+        dst := uniqueBackupPath(filepath.Join(dir, name), f.Name()[len(name):])
+        if err := moveFile(f.path, dst); err == nil {
+            w.applyPerm(dst, w.opts.FileMode)
+            w.emit(EventArchived, dst, f.path)
+        }
+    }
 }
 
 // removeFiles deletes expired or redundant log files
 func (w *RollWriter) removeFiles(remove []logInfo) {
     // clean expired or redundant files
     for _, f := range remove {
-        _ = os.Remove(filepath.Join(w.currDir, f.Name()))
+        if err := os.Remove(f.path); err == nil {
+            w.emit(EventDeleted, f.path, "")
+            w.removeEmptyDirs(filepath.Dir(f.path))
+        }
+    }
+}
+
+// removeEmptyDirs removes the empty dated directories of archive after scavenging
+func (w *RollWriter) removeEmptyDirs(dir string) {
+    if w.opts.ArchiveDir == "" {
+        return
+    }
+    root := filepath.Clean(w.opts.ArchiveDir)
+    for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+        // fails when the directory is not empty
+        if os.Remove(dir) != nil {
+            return
+        }
     }
 }
 
 // compressFiles compresses demanded log files.
+// Files are compressed by at most CompressConcurrency workers.
 func (w *RollWriter) compressFile(compress []logInfo) {
-    // compress log files
+    if len(compress) == 0 {
+        return
+    }
+    ch := make(chan string, len(compress))
     for _, f := range compress {
-        fn := filepath.Join(w.currDir, f.Name())
-        _ = compressFile(fn, fn+compressSuffix)
+        ch <- f.path
+    }
+    close(ch)
+    var wg sync.WaitGroup
+    for i := 0; i < w.opts.CompressConcurrency && i < len(compress); i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            // compress log files
+            for fn := range ch {
+                err := compressFile(fn, fn+w.opts.Codec.Ext(), w.opts.Codec, w.limiter, w.fileMode(), w.stopCh)
+                if err == errStopped {
+                    return
+                }
+                if err == nil {
+                    w.applyPerm(fn+w.opts.Codec.Ext(), w.opts.FileMode)
+                    w.emit(EventCompressed, fn+w.opts.Codec.Ext(), fn)
+                }
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+// recoverCompress resumes the compressing interrupted by crash. The temporary files are removed, and if both
+// the original and the compressed file exist, the original one is removed only when the compressed one is valid.
+// The remaining originals are compressed again by the scavenger.
+func (w *RollWriter) recoverCompress() {
+    var paths []string
+    if files, err := ioutil.ReadDir(w.currDir); err == nil {
+        for _, f := range files {
+            if f.Mode().IsRegular() {
+                paths = append(paths, filepath.Join(w.currDir, f.Name()))
+            }
+        }
+    }
+    if w.opts.ArchiveDir != "" {
+        _ = filepath.Walk(w.opts.ArchiveDir, func(path string, f os.FileInfo, err error) error {
+            if err == nil && f.Mode().IsRegular() {
+                paths = append(paths, path)
+            }
+            return nil
+        })
+    }
+    for _, path := range paths {
+        name := filepath.Base(path)
+        if strings.HasSuffix(name, compressTmpSuffix) {
+            name = strings.TrimSuffix(name, compressTmpSuffix)
+            if _, _, ok := w.parseBackupName(name, time.Time{}); ok && hasCompressExt(name) {
+                _ = os.Remove(path)
+            }
+            continue
+        }
+        codec := codecByExt(name)
+        if _, _, ok := w.parseBackupName(name, time.Time{}); !ok || codec == nil {
+            continue
+        }
+        src := trimCompressExt(path)
+        st, err := os.Stat(src)
+        if err != nil {
+            continue
+        }
+        if verifyCompressed(path, codec, st.Size()) == nil {
+            _ = os.Remove(src)
+        } else {
+            _ = os.Remove(path)
+        }
     }
 }
 
-// getOldLogFiles returns the log file list ordered by modified time
+// getOldLogFiles returns the log file list ordered by modified time, including the ones in archive directory
 func (w *RollWriter) getOldLogFiles() ([]logInfo, error) {
-    files, err := ioutil.ReadDir(w.currDir)
+    logFiles, err := w.readLogDir(w.currDir)
+    if err != nil {
+        return nil, err
+    }
+    if w.opts.ArchiveDir != "" {
+        _ = filepath.Walk(w.opts.ArchiveDir, func(path string, f os.FileInfo, err error) error {
+            if err != nil || !f.Mode().IsRegular() {
+                return nil
+            }
+            if start, end, ok := w.parseBackupName(f.Name(), f.ModTime()); ok {
+                logFiles = append(logFiles, logInfo{timestamp: end, start: start, path: path, FileInfo: f})
+            }
+            return nil
+        })
+    }
+    sort.Sort(byFormatTime(logFiles))
+    return logFiles, nil
+}
+
+// readLogDir returns the old log files in dir, excluding the current one
+func (w *RollWriter) readLogDir(dir string) ([]logInfo, error) {
+    files, err := ioutil.ReadDir(dir)
     if err != nil {
         return nil, fmt.Errorf("can't read log file directory: %s", err)
     }
     var logFiles []logInfo
-    fileName := filepath.Base(w.filePath)
     for _, f := range files {
-        if f.IsDir() {
+        // skip the symlink to current log file
+        if f.IsDir() || f.Mode()&os.ModeSymlink != 0 {
+            continue
+        }
+        // exclude current log file
+        if filepath.Base(w.currPath) == f.Name() {
             continue
         }
-        if modTime, err := w.matchLogFile(f.Name(), fileName); err == nil {
-            logFiles = append(logFiles, logInfo{modTime, f})
+        if start, end, ok := w.parseBackupName(f.Name(), f.ModTime()); ok {
+            logFiles = append(logFiles, logInfo{timestamp: end, start: start, path: filepath.Join(dir, f.Name()),
+                FileInfo: f})
         }
     }
-    sort.Sort(byFormatTime(logFiles))
     return logFiles, nil
 }
 
-// matchLogFile checks whether current log file matches all relative log files, if matched, returns the modified time
-func (w *RollWriter) matchLogFile(filename, filePrefix string) (time.Time, error) {
-    // exclude current log file
-    // a.log
-    // a.log.20220624
-    if filepath.Base(w.currPath) == filename {
-        return time.Time{}, errors.New("ignore current logfile")
+// parseBackupName checks whether name is an old log file produced by this writer, if matched, returns the
+// start and end of the period which the file holds logs of, parsed from the name. They are like:
+// a.log.20221016 (rolled by time, the day and the end of period), a.log.bk-20221016-150405.00000 (rolled by
+// size, the backup time for both), a.log.1 (numbered, modTime for both), and any of them with compression
+// extension like a.log.1.gz
+func (w *RollWriter) parseBackupName(name string, modTime time.Time) (start, end time.Time, ok bool) {
+    fileName := filepath.Base(w.filePath)
+    name = trimCompressExt(name)
+    if !strings.HasPrefix(name, fileName) {
+        return time.Time{}, time.Time{}, false
+    }
+    rest := name[len(fileName):]
+    start, end = modTime, modTime
+    rolled := false
+    // the time part of rolling by time, like .20221016
+    if w.opts.TimeFormat != "" {
+        layout, ok := strftimeLayout(w.opts.TimeFormat)
+        if !ok || len(rest) < len(layout) {
+            return time.Time{}, time.Time{}, false
+        }
+        loc := w.opts.Location
+        if loc == nil {
+            loc = time.Local
+        }
+        t, err := time.ParseInLocation(layout, rest[:len(layout)], loc)
+        if err != nil {
+            return time.Time{}, time.Time{}, false
+        }
+        start = t
+        if w.opts.RotationPeriod != nil {
+            start, end = w.opts.RotationPeriod(t)
+        }
+        rest, rolled = rest[len(layout):], true
+    }
+    // the backup part of rolling by size
+    if rest == "" {
+        return start, end, rolled
+    }
+    // the suffix added to duplicate names of time-rolled files in archive, orders after the original one
+    if rolled && rest[0] == '-' {
+        if n, err := strconv.Atoi(rest[1:]); err == nil && n > 0 {
+            return start, end.Add(time.Duration(n)), true
+        }
+        return time.Time{}, time.Time{}, false
     }
-    // check if there is the same prefix
-    if !strings.HasPrefix(filename, filePrefix) {
-        return time.Time{}, errors.New("mismatched prefix")
+    if rest[0] != '.' {
+        return time.Time{}, time.Time{}, false
     }
-    if st, _ := os.Stat(filepath.Join(w.currDir, filename)); st != nil {
-        return st.ModTime(), nil
+    suffix := rest[1:]
+    loc := time.Local
+    if w.opts.BackupUTC {
+        loc = time.UTC
     }
-    return time.Time{}, errors.New("file stat fail")
+    // a layout of digits only like "20060102" also looks like a numbered backup
+    if !w.opts.NumberedBackup {
+        if t, err := time.ParseInLocation(w.opts.BackupTimeFormat, suffix, loc); err == nil {
+            return t, t, true
+        }
+    }
+    if n, err := strconv.Atoi(suffix); err == nil && n > 0 {
+        return modTime, modTime, true
+    }
+    if t, err := time.ParseInLocation(backupTimeFormat, suffix, time.Local); err == nil {
+        return t, t, true
+    }
+    if t, err := time.ParseInLocation(w.opts.BackupTimeFormat, suffix, loc); err == nil {
+        return t, t, true
+    }
+    // the suffix added to duplicate names, like a.log.20221016-1, orders after the original one
+    if i := strings.LastIndexByte(suffix, '-'); i > 0 {
+        n, err := strconv.Atoi(suffix[i+1:])
+        if err != nil || n <= 0 {
+            return time.Time{}, time.Time{}, false
+        }
+        if t, err := time.ParseInLocation(w.opts.BackupTimeFormat, suffix[:i], loc); err == nil {
+            return t.Add(time.Duration(n)), t.Add(time.Duration(n)), true
+        }
+    }
+    return time.Time{}, time.Time{}, false
 }
 
 // getCurrFile returns the current log file
@@ -249,11 +927,16 @@ func (w *RollWriter) getCurrFile() *os.File {
 func (w *RollWriter) doReopenFile(path string) error {
     atomic.StoreInt64(&w.openTime, time.Now().Unix())
     lastFile := w.getCurrFile()
-    of, err := os.OpenFile(path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0666)
+    of, err := os.OpenFile(path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, w.fileMode())
     if err != nil {
         return err
     }
     w.setCurrFile(of)
+    w.updateSymlink(path)
+    if !sameFile(lastFile, of) {
+        w.applyPerm(path, w.opts.FileMode)
+        w.emit(EventOpened, path, "")
+    }
     if lastFile != nil {
         // delay closing until not used
         w.closeCh <- lastFile
@@ -265,6 +948,114 @@ func (w *RollWriter) doReopenFile(path string) error {
     return nil
 }
 
+// updateSymlink points the symlink to the log file atomically, by renaming a temporary link over it.
+// It skips if the path of symlink is taken by other kinds of files, which must not be replaced
+func (w *RollWriter) updateSymlink(path string) {
+    if w.opts.Symlink == "" || filepath.Clean(w.opts.Symlink) == filepath.Clean(path) {
+        return
+    }
+    if !isSymlinkOrMissing(w.opts.Symlink) {
+        return
+    }
+    target := path
+    if filepath.Dir(w.opts.Symlink) == filepath.Dir(path) {
+        target = filepath.Base(path)
+    } else if abs, err := filepath.Abs(path); err == nil {
+        target = abs
+    }
+    if curr, err := os.Readlink(w.opts.Symlink); err == nil && curr == target {
+        return
+    }
+    tmp := w.opts.Symlink + ".tmp"
+    if !isSymlinkOrMissing(tmp) {
+        return
+    }
+    _ = os.Remove(tmp)
+    if err := os.Symlink(target, tmp); err != nil {
+        return
+    }
+    if err := os.Rename(tmp, w.opts.Symlink); err != nil {
+        _ = os.Remove(tmp)
+    }
+}
+
+// isSymlinkOrMissing checks whether path is a symlink or does not exist
+func isSymlinkOrMissing(path string) bool {
+    st, err := os.Lstat(path)
+    if err != nil {
+        return os.IsNotExist(err)
+    }
+    return st.Mode()&os.ModeSymlink != 0
+}
+
+// fileMode returns the mode to create log files, default 0666 before umask
+func (w *RollWriter) fileMode() os.FileMode {
+    if w.opts.FileMode != 0 {
+        return w.opts.FileMode
+    }
+    return 0666
+}
+
+// mkdirAll creates dir and its missing parents with DirMode, and applies the owner to the created ones
+func (w *RollWriter) mkdirAll(dir string) error {
+    var created []string
+    for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+        if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+            break
+        }
+        created = append(created, d)
+    }
+    mode := w.opts.DirMode
+    if mode == 0 {
+        mode = 0755
+    }
+    if err := os.MkdirAll(dir, mode); err != nil {
+        return err
+    }
+    for _, d := range created {
+        w.applyPerm(d, w.opts.DirMode)
+    }
+    return nil
+}
+
+// applyPerm sets the configured mode regardless of umask, and the owner of path.
+// Errors are ignored as the log file still works with the default permissions.
+func (w *RollWriter) applyPerm(path string, mode os.FileMode) {
+    applyPerm(path, mode, w.opts.Uid, w.opts.Gid)
+}
+
+// applyPerm sets mode regardless of umask and the owner of path, 0 means unchanged
+func applyPerm(path string, mode os.FileMode, uid, gid int) {
+    if mode != 0 {
+        _ = os.Chmod(path, mode)
+    }
+    if uid > 0 || gid > 0 {
+        if uid <= 0 {
+            uid = -1
+        }
+        if gid <= 0 {
+            gid = -1
+        }
+        _ = os.Chown(path, uid, gid)
+    }
+}
+
+// sameFile checks whether the two opened files are the same one
+func sameFile(a, b *os.File) bool {
+    if a == nil || b == nil {
+        return false
+    }
+    sa, err := a.Stat()
+    if err != nil {
+        return false
+    }
+    sb, err := b.Stat()
+    if err != nil {
+        return false
+    }
+    return os.SameFile(sa, sb)
+}
+
 // setCurrFile sets the current log file
 func (w *RollWriter) setCurrFile(file *os.File) {
     w.currFile.Store(file)
@@ -1,5 +1,10 @@
 package rollwriter
 
+import (
+    "os"
+    "time"
+)
+
 // Options is the rollwriter call options
 type Options struct {
     // MaxSize is max size by byte of the log file
@@ -8,12 +13,73 @@ type Options struct {
     MaxBackups int
     // MaxAge is the max expire time by day of log files
     MaxAge int
+    // MaxTotalSize is the max size by byte of current log file and all backups
+    MaxTotalSize int64
+    // MinFreeDisk is the min free space by byte of the disk which log files are on
+    MinFreeDisk int64
     // Compress is the whether the log file should be compressed
     Compress bool
+    // Codec is the compression algorithm of log files, default gzip
+    Codec Codec
+    // CompressConcurrency is the max number of files compressed at the same time, default 1
+    CompressConcurrency int
+    // CompressRateLimit is the max bytes per second read by compressing, default no limit
+    CompressRateLimit int64
     // TimeFormat is the time format to split log file by time
     TimeFormat string
+    // RotationPeriod returns the rolling period which the time is in, to split log file exactly at the end
+    RotationPeriod RotationPeriod
+    // Location is the location of time to split log file
+    Location *time.Location
+    // StatInterval is the interval to check whether the log file is deleted or replaced externally
+    StatInterval time.Duration
+    // CleanInterval is the interval of periodic retention sweeps besides the ones on rotation, 0 means none
+    CleanInterval time.Duration
+    // NumberedBackup is whether backups are named by number(a.log.1) instead of time
+    NumberedBackup bool
+    // BackupTimeFormat is the time layout of backup names
+    BackupTimeFormat string
+    // BackupUTC is whether the time of backup names uses UTC
+    BackupUTC bool
+    // Symlink is the path of the symlink to current log file
+    Symlink string
+    // ArchiveDir is the directory which finished log files are moved into
+    ArchiveDir string
+    // ArchiveLayout is the time format(%Y/%m/%d) of sub directory in ArchiveDir
+    ArchiveLayout string
+    // FsyncPolicy is when to commit log file to disk, default FsyncNever
+    FsyncPolicy FsyncPolicy
+    // FsyncInterval is the interval to commit log file to disk with FsyncInterval policy
+    FsyncInterval time.Duration
+    // FileMode is the mode of log files, default 0666 before umask
+    FileMode os.FileMode
+    // DirMode is the mode of created directories, default 0755 before umask
+    DirMode os.FileMode
+    // Uid is the owner user id of log files and created directories, 0 means unchanged
+    Uid int
+    // Gid is the owner group id of log files and created directories, 0 means unchanged
+    Gid int
+    // EventHandler is called on file events like opened/rotated/compressed/deleted
+    EventHandler EventHandler
 }
 
+// RotationPeriod returns the start and end time of the rolling period which t is in
+type RotationPeriod func(t time.Time) (start, end time.Time)
+
+// FsyncPolicy is when to commit log file to disk by fsync
+type FsyncPolicy int
+
+const (
+    // FsyncNever never calls fsync, leaving it to the operating system
+    FsyncNever FsyncPolicy = iota
+    // FsyncOnSync calls fsync on Sync
+    FsyncOnSync
+    // FsyncInterval calls fsync every FsyncInterval and on Sync
+    FsyncInterval
+    // FsyncAlways calls fsync after every write
+    FsyncAlways
+)
+
 // Option modifies the Options
 type Option func(*Options)
 
@@ -38,6 +104,20 @@ func WithMaxBackups(n int) Option {
     }
 }
 
+// WithMaxTotalSize returns an Option which sets the max total size(MB) of current log file and all backups.
+func WithMaxTotalSize(n int) Option {
+    return func(o *Options) {
+        o.MaxTotalSize = int64(n) * 1024 * 1024
+    }
+}
+
+// WithMinFreeDisk returns an Option which sets the min free space(MB) of the disk which log files are on.
+func WithMinFreeDisk(n int) Option {
+    return func(o *Options) {
+        o.MinFreeDisk = int64(n) * 1024 * 1024
+    }
+}
+
 // WithCompress returns an Option which sets whether log files should be compressed.
 func WithCompress(b bool) Option {
     return func(o *Options) {
@@ -45,9 +125,140 @@ func WithCompress(b bool) Option {
     }
 }
 
+// WithCodec returns an Option which sets the compression algorithm of log files.
+func WithCodec(c Codec) Option {
+    return func(o *Options) {
+        o.Codec = c
+    }
+}
+
+// WithCompressConcurrency returns an Option which sets the max number of files compressed at the same time.
+func WithCompressConcurrency(n int) Option {
+    return func(o *Options) {
+        o.CompressConcurrency = n
+    }
+}
+
+// WithCompressRateLimit returns an Option which sets the max throughput(MB/s) of compressing.
+func WithCompressRateLimit(n int) Option {
+    return func(o *Options) {
+        o.CompressRateLimit = int64(n) * 1024 * 1024
+    }
+}
+
 // WithRotationTime returns an Option which sets the time format(%Y%m%d) to roll logs.
 func WithRotationTime(s string) Option {
     return func(o *Options) {
         o.TimeFormat = s
     }
 }
+
+// WithRotationPeriod returns an Option which sets the rolling period, log file is split exactly at its end.
+func WithRotationPeriod(p RotationPeriod) Option {
+    return func(o *Options) {
+        o.RotationPeriod = p
+    }
+}
+
+// WithLocation returns an Option which sets the location of time to split log file.
+func WithLocation(loc *time.Location) Option {
+    return func(o *Options) {
+        o.Location = loc
+    }
+}
+
+// WithStatInterval returns an Option which sets the interval(ms) to check whether the log file is deleted
+// or replaced externally.
+func WithStatInterval(n int) Option {
+    return func(o *Options) {
+        o.StatInterval = time.Duration(n) * time.Millisecond
+    }
+}
+
+// WithCleanInterval returns an Option which sets the interval(seconds) of periodic retention sweeps, so that
+// MaxAge and other limits are enforced even if the log file is rarely rotated.
+func WithCleanInterval(n int) Option {
+    return func(o *Options) {
+        o.CleanInterval = time.Duration(n) * time.Second
+    }
+}
+
+// WithNumberedBackup returns an Option which sets whether backups are named by number like logrotate.
+func WithNumberedBackup(b bool) Option {
+    return func(o *Options) {
+        o.NumberedBackup = b
+    }
+}
+
+// WithBackupTimeFormat returns an Option which sets the time layout(20060102-150405) of backup names.
+func WithBackupTimeFormat(s string) Option {
+    return func(o *Options) {
+        o.BackupTimeFormat = s
+    }
+}
+
+// WithBackupUTC returns an Option which sets whether the time of backup names uses UTC.
+func WithBackupUTC(b bool) Option {
+    return func(o *Options) {
+        o.BackupUTC = b
+    }
+}
+
+// WithSymlink returns an Option which sets the path of symlink that always points to current log file.
+func WithSymlink(s string) Option {
+    return func(o *Options) {
+        o.Symlink = s
+    }
+}
+
+// WithFsyncPolicy returns an Option which sets when to commit log file to disk, and the interval(ms)
+// for FsyncInterval policy.
+func WithFsyncPolicy(p FsyncPolicy, interval int) Option {
+    return func(o *Options) {
+        o.FsyncPolicy = p
+        o.FsyncInterval = time.Duration(interval) * time.Millisecond
+    }
+}
+
+// WithFileMode returns an Option which sets the mode of log files, like 0600.
+func WithFileMode(m os.FileMode) Option {
+    return func(o *Options) {
+        o.FileMode = m
+    }
+}
+
+// WithDirMode returns an Option which sets the mode of created directories, like 0750.
+func WithDirMode(m os.FileMode) Option {
+    return func(o *Options) {
+        o.DirMode = m
+    }
+}
+
+// WithOwner returns an Option which sets the owner user id and group id of log files, 0 means unchanged.
+func WithOwner(uid, gid int) Option {
+    return func(o *Options) {
+        o.Uid = uid
+        o.Gid = gid
+    }
+}
+
+// WithEventHandler returns an Option which sets the handler of file events like opened/rotated/compressed/deleted.
+func WithEventHandler(h EventHandler) Option {
+    return func(o *Options) {
+        o.EventHandler = h
+    }
+}
+
+// WithArchiveDir returns an Option which sets the directory that finished log files are moved into.
+func WithArchiveDir(s string) Option {
+    return func(o *Options) {
+        o.ArchiveDir = s
+    }
+}
+
+// WithArchiveLayout returns an Option which sets the time format(%Y/%m/%d) of sub directory in archive directory.
+func WithArchiveLayout(s string) Option {
+    return func(o *Options) {
+        o.ArchiveLayout = s
+    }
+}
@@ -0,0 +1,275 @@
+package rollwriter
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/lestrrat-go/strftime"
+)
+
+// newTestWriter creates a RollWriter of dir/app.log without opening files or starting goroutines,
+// filling the defaults like NewRollWriter
+func newTestWriter(dir string, opts Options) *RollWriter {
+    if opts.BackupTimeFormat == "" {
+        opts.BackupTimeFormat = backupTimeFormat
+    }
+    if opts.Codec == nil {
+        opts.Codec = NewGzipCodec(0)
+    }
+    path := filepath.Join(dir, "app.log")
+    return &RollWriter{filePath: path, opts: &opts, currDir: dir, currPath: path}
+}
+
+// dayOptions returns the options of rolling by day in UTC
+func dayOptions() Options {
+    return Options{
+        TimeFormat: ".%Y%m%d",
+        Location: time.UTC,
+        RotationPeriod: func(t time.Time) (time.Time, time.Time) {
+            start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+            return start, start.AddDate(0, 0, 1)
+        },
+    }
+}
+
+func TestParseBackupName(t *testing.T) {
+    modTime := time.Date(2022, 10, 18, 8, 0, 0, 0, time.Local)
+    bk := time.Date(2022, 10, 16, 15, 4, 5, 0, time.Local)
+    day := time.Date(2022, 10, 16, 0, 0, 0, 0, time.UTC)
+    next := day.AddDate(0, 0, 1)
+    date := time.Date(2022, 10, 16, 0, 0, 0, 0, time.Local)
+    coarse := Options{BackupTimeFormat: "20060102"}
+    tests := []struct {
+        name string
+        opts Options
+        ok bool
+        start, end time.Time
+    }{
+        {"app.log.bk-20221016-150405.00000", Options{}, true, bk, bk},
+        {"app.log.bk-20221016-150405.00000.gz", Options{}, true, bk, bk},
+        {"app.log.bk-20221016-150405.00000.zst", Options{}, true, bk, bk},
+        {"app.log.bk-20221016-150405.00000-2", Options{}, true, bk.Add(2), bk.Add(2)},
+        {"app.log.bk-20221016-150405.00000-2.lz4", Options{}, true, bk.Add(2), bk.Add(2)},
+        {"app.log.bk-20221016-150405.00000-0", Options{}, false, time.Time{}, time.Time{}},
+        {"app.log.bk-20221016-150405.00000.gz.tmp", Options{}, false, time.Time{}, time.Time{}},
+        {"app.log.3", Options{}, true, modTime, modTime},
+        {"app.log.3.sz", Options{}, true, modTime, modTime},
+        {"app.log.0", Options{}, false, time.Time{}, time.Time{}},
+        {"app.log.bak-manual", Options{}, false, time.Time{}, time.Time{}},
+        {"app.logger.txt", Options{}, false, time.Time{}, time.Time{}},
+        {"app.log.spill", Options{}, false, time.Time{}, time.Time{}},
+        {"app.log", Options{}, false, time.Time{}, time.Time{}},
+        {"other.log.1", Options{}, false, time.Time{}, time.Time{}},
+        // a coarse backup time layout of digits only is not taken as a numbered backup
+        {"app.log.20221016", coarse, true, date, date},
+        {"app.log.20221016-1", coarse, true, date.Add(1), date.Add(1)},
+        // rolled by time, the period of the name
+        {"app.log.20221016", dayOptions(), true, day, next},
+        {"app.log.20221016.gz", dayOptions(), true, day, next},
+        {"app.log.20221016-1", dayOptions(), true, day, next.Add(1)},
+        {"app.log.20221016-1.gz", dayOptions(), true, day, next.Add(1)},
+        {"app.log.20221016-x", dayOptions(), false, time.Time{}, time.Time{}},
+        {"app.log.20221016.bk-20221016-150405.00000", dayOptions(), true, bk, bk},
+        {"app.log.20221016.gz.tmp", dayOptions(), false, time.Time{}, time.Time{}},
+        {"app.log.20221016.spill", dayOptions(), false, time.Time{}, time.Time{}},
+        {"app.log.2022101", dayOptions(), false, time.Time{}, time.Time{}},
+        {"app.log.spill", dayOptions(), false, time.Time{}, time.Time{}},
+    }
+    for _, tt := range tests {
+        w := newTestWriter(t.TempDir(), tt.opts)
+        start, end, ok := w.parseBackupName(tt.name, modTime)
+        if ok != tt.ok {
+            t.Errorf("parseBackupName(%q) ok = %v, want %v", tt.name, ok, tt.ok)
+            continue
+        }
+        if ok && (!start.Equal(tt.start) || !end.Equal(tt.end)) {
+            t.Errorf("parseBackupName(%q) = %v, %v, want %v, %v", tt.name, start, end, tt.start, tt.end)
+        }
+    }
+}
+
+// createFiles creates small files in dir, modified at the time of each name
+func createFiles(t *testing.T, dir string, files map[string]time.Time) {
+    for name, modTime := range files {
+        path := filepath.Join(dir, name)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := ioutil.WriteFile(path, []byte("log\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Chtimes(path, modTime, modTime); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestPlanFiles(t *testing.T) {
+    now := time.Now()
+    tests := []struct {
+        desc string
+        opts Options
+        files map[string]time.Time
+        compress, remove []string
+    }{
+        {
+            desc: "max backups by time",
+            opts: Options{MaxBackups: 2, Compress: true},
+            files: map[string]time.Time{
+                "app.log": now,
+                "app.log.bk-20221016-150405.00000": now,
+                "app.log.bk-20221016-150405.00000-1": now,
+                "app.log.bk-20221015-150405.00000.gz": now,
+                "app.log.bk-20221014-150405.00000.gz.tmp": now,
+                "app.log.bak-manual": now,
+                "app.logger.txt": now,
+                "app.log.spill": now,
+            },
+            compress: []string{"app.log.bk-20221016-150405.00000-1", "app.log.bk-20221016-150405.00000"},
+            remove: []string{"app.log.bk-20221015-150405.00000.gz"},
+        },
+        {
+            desc: "max age of numbered backups",
+            opts: Options{MaxAge: 2, Compress: true, NumberedBackup: true},
+            files: map[string]time.Time{
+                "app.log": now,
+                "app.log.1": now.Add(-time.Hour),
+                "app.log.2.zst": now.Add(-24 * time.Hour),
+                "app.log.3.gz": now.Add(-72 * time.Hour),
+                "app.log.bak-manual": now.Add(-72 * time.Hour),
+            },
+            compress: []string{"app.log.1"},
+            remove: []string{"app.log.3.gz"},
+        },
+        {
+            desc: "max age by the end of period",
+            opts: func() Options {
+                o := dayOptions()
+                o.MaxAge = 1
+                return o
+            }(),
+            files: map[string]time.Time{
+                now.UTC().Format("app.log.20060102"): now,
+                now.UTC().AddDate(0, 0, -1).Format("app.log.20060102"): now,
+                now.UTC().AddDate(0, 0, -3).Format("app.log.20060102-1"): now,
+                now.UTC().AddDate(0, 0, -3).Format("app.log.20060102.gz"): now,
+                "app.log.spill": now.AddDate(0, 0, -3),
+            },
+            remove: []string{
+                now.UTC().AddDate(0, 0, -3).Format("app.log.20060102-1"),
+                now.UTC().AddDate(0, 0, -3).Format("app.log.20060102.gz"),
+            },
+        },
+    }
+    for _, tt := range tests {
+        dir := t.TempDir()
+        createFiles(t, dir, tt.files)
+        w := newTestWriter(dir, tt.opts)
+        if tt.opts.TimeFormat != "" {
+            w.currPath = filepath.Join(dir, now.UTC().Format("app.log.20060102"))
+        }
+        plan, err := w.Plan()
+        if err != nil {
+            t.Fatalf("%s: Plan: %v", tt.desc, err)
+        }
+        if got, want := plan.Compress, joinPaths(dir, tt.compress); !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: compress = %v, want %v", tt.desc, got, want)
+        }
+        if got, want := plan.Remove, joinPaths(dir, tt.remove); !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: remove = %v, want %v", tt.desc, got, want)
+        }
+    }
+}
+
+// joinPaths joins the names to dir, or returns nil if there are none
+func joinPaths(dir string, names []string) []string {
+    var paths []string
+    for _, name := range names {
+        paths = append(paths, filepath.Join(dir, name))
+    }
+    return paths
+}
+
+func TestArchiveFiles(t *testing.T) {
+    dir := t.TempDir()
+    opts := dayOptions()
+    opts.ArchiveDir = filepath.Join(dir, "archive")
+    createFiles(t, dir, map[string]time.Time{
+        "app.log.20221016": time.Now(),
+        "app.log.20221017.gz": time.Now(),
+        "archive/2022/10/17/app.log.20221017.gz": time.Now(),
+    })
+    w := newTestWriter(dir, opts)
+    w.currPath = filepath.Join(dir, "app.log.20221018")
+    w.archivePattern, _ = strftime.New(defaultArchiveLayout)
+    w.archiveFiles()
+    // files go to the dated directory of the day they hold logs of, not the day they are finished
+    for _, name := range []string{"2022/10/16/app.log.20221016", "2022/10/17/app.log.20221017-1.gz"} {
+        if _, err := os.Stat(filepath.Join(opts.ArchiveDir, name)); err != nil {
+            t.Errorf("archived file %s: %v", name, err)
+        }
+    }
+}
+
+func TestCloseStopsCompressing(t *testing.T) {
+    dir := t.TempDir()
+    backup := filepath.Join(dir, "app.log.bk-20221016-150405.00000")
+    // 4MB takes 4 seconds to compress at 1MB/s
+    if err := ioutil.WriteFile(backup, make([]byte, 4 * 1024 * 1024), 0644); err != nil {
+        t.Fatal(err)
+    }
+    w, err := NewRollWriter(filepath.Join(dir, "app.log"), WithCompress(true), WithCompressRateLimit(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    // let the sweep on opening start compressing
+    time.Sleep(100 * time.Millisecond)
+    start := time.Now()
+    if err := w.Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+    if d := time.Since(start); d > time.Second {
+        t.Errorf("Close waits for compressing %v", d)
+    }
+    if _, err := os.Stat(backup + compressSuffix + compressTmpSuffix); !os.IsNotExist(err) {
+        t.Errorf("temporary file is left, err: %v", err)
+    }
+    if _, err := os.Stat(backup); err != nil {
+        t.Errorf("backup is removed without compressing, err: %v", err)
+    }
+}
+
+func TestSymlinkKeepsRegularFile(t *testing.T) {
+    dir := t.TempDir()
+    symlink := filepath.Join(dir, "current.log")
+    if err := ioutil.WriteFile(symlink, []byte("not a link\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    w, err := NewRollWriter(filepath.Join(dir, "app.log"), WithSymlink(symlink))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer w.Close()
+    data, err := ioutil.ReadFile(symlink)
+    if err != nil || string(data) != "not a link\n" {
+        t.Errorf("regular file at symlink path is replaced, data: %q, err: %v", data, err)
+    }
+
+    // an existing symlink is pointed to the log file
+    if err := os.Remove(symlink); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Symlink("other.log", symlink); err != nil {
+        t.Fatal(err)
+    }
+    if err := w.Rotate(); err != nil {
+        t.Fatal(err)
+    }
+    if target, err := os.Readlink(symlink); err != nil || target != "app.log" {
+        t.Errorf("symlink points to %q, err: %v", target, err)
+    }
+}
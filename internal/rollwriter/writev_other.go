@@ -0,0 +1,19 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package rollwriter
+
+import "os"
+
+// writeBuffers writes bufs to f one by one as writev is not supported on this platform
+func writeBuffers(f *os.File, bufs [][]byte) (int, error) {
+    total := 0
+    for _, b := range bufs {
+        n, err := f.Write(b)
+        total += n
+        if err != nil {
+            return total, err
+        }
+    }
+    return total, nil
+}
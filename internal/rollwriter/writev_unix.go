@@ -0,0 +1,65 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package rollwriter
+
+import (
+    "io"
+    "os"
+    "syscall"
+    "unsafe"
+)
+
+// maxIovecs is the max number of buffers written by a writev, IOV_MAX of linux and darwin
+const maxIovecs = 1024
+
+// writeBuffers writes bufs to f by writev, so that a batch of logs in different buffers is written by one
+// system call without copying. bufs are consumed on partial writes
+func writeBuffers(f *os.File, bufs [][]byte) (int, error) {
+    rc, err := f.SyscallConn()
+    if err != nil {
+        return 0, err
+    }
+    iovs := make([]syscall.Iovec, 0, maxIovecs)
+    total := 0
+    for {
+        iovs = iovs[:0]
+        for _, b := range bufs {
+            if len(iovs) == maxIovecs {
+                break
+            }
+            if len(b) == 0 {
+                continue
+            }
+            iov := syscall.Iovec{Base: &b[0]}
+            iov.SetLen(len(b))
+            iovs = append(iovs, iov)
+        }
+        if len(iovs) == 0 {
+            return total, nil
+        }
+        var (
+            n uintptr
+            errno syscall.Errno
+        )
+        err = rc.Write(func(fd uintptr) bool {
+            n, _, errno = syscall.Syscall(syscall.SYS_WRITEV, fd, uintptr(unsafe.Pointer(&iovs[0])),
+                uintptr(len(iovs)))
+            return errno != syscall.EAGAIN
+        })
+        if err != nil {
+            return total, err
+        }
+        if errno == syscall.EINTR {
+            continue
+        }
+        if errno != 0 {
+            return total, &os.PathError{Op: "writev", Path: f.Name(), Err: errno}
+        }
+        if n == 0 {
+            return total, io.ErrShortWrite
+        }
+        total += int(n)
+        bufs = consumeBuffers(bufs, int(n))
+    }
+}
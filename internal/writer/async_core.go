@@ -0,0 +1,270 @@
+package writer
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/noahyzhang/zlog/config"
+    "github.com/noahyzhang/zlog/internal/rollwriter"
+    "go.uber.org/zap"
+    "go.uber.org/zap/zapcore"
+    "time"
+)
+
+// backpressures maps the config backpressure policy to rollwriter
+var backpressures = map[config.BackpressureType]rollwriter.Backpressure{
+    config.BackpressureBlock:        rollwriter.BlockForever,
+    config.BackpressureDropNewest:   rollwriter.DropNewest,
+    config.BackpressureBlockTimeout: rollwriter.BlockTimeout,
+    config.BackpressureDropOldest:   rollwriter.DropOldest,
+    config.BackpressureDropLowLevel: rollwriter.DropLowPriority,
+    config.BackpressureSpill:        rollwriter.Spill,
+}
+
+// isAsync checks whether the output writes asynchronously, def is used when the write mode is unset
+func isAsync(c *config.OutputConfig, def bool) bool {
+    switch c.WriterConfig.WriteMode {
+    case config.WriteSync:
+        return false
+    case config.WriteAsync, config.WriteFast:
+        return true
+    default:
+        return def
+    }
+}
+
+// NewCore creates the core of an output writing encoded logs to ws by the output config, asynchronously in
+// async write modes, and returns its level. Outputs default to sync write mode
+func NewCore(c *config.OutputConfig, ws zapcore.WriteSyncer) (zapcore.Core, zap.AtomicLevel) {
+    enabler, lvl := newLevelEnabler(c)
+    return newCore(c, ws, enabler, c.WriterName.ToString(), false), lvl
+}
+
+// newCore creates the core of an output writing encoded logs to ws, asynchronously in async write modes.
+// Output factories use it to support all the write modes, def is whether to write asynchronously by default
+func newCore(c *config.OutputConfig, ws zapcore.WriteSyncer, enabler zapcore.LevelEnabler, name string,
+    def bool) zapcore.Core {
+    if isAsync(c, def) {
+        return newAsyncCore(c, ws, enabler, name)
+    }
+    return withFlushLevel(c, zapcore.NewCore(newEncoder(c), ws, enabler))
+}
+
+// newAsyncCore creates the core writing to ws asynchronously by the write config, name is reported in stats
+func newAsyncCore(c *config.OutputConfig, ws zapcore.WriteSyncer, enabler zapcore.LevelEnabler,
+    name string) *asyncCore {
+    dropLog := c.WriterConfig.WriteMode == config.WriteFast
+    opts := []rollwriter.AsyncOption{rollwriter.WithDropLog(dropLog)}
+    if p, ok := backpressures[c.WriterConfig.Backpressure]; ok {
+        timeout := c.WriterConfig.BlockTimeout
+        if timeout <= 0 {
+            timeout = 100
+        }
+        opts = append(opts, rollwriter.WithBackpressure(p, timeout))
+    }
+    if c.WriterConfig.Backpressure == config.BackpressureSpill {
+        path := c.WriterConfig.SpillPath
+        if path == "" && c.WriterName == config.OutputFile {
+            path = c.WriterConfig.FileName + ".spill"
+        }
+        opts = append(opts, rollwriter.WithSpill(path, c.WriterConfig.SpillMaxSize),
+            rollwriter.WithSpillPerm(c.WriterConfig.FileMode, c.WriterConfig.Uid, c.WriterConfig.Gid))
+    }
+    if n := c.WriterConfig.QueueSize; n > 0 {
+        opts = append(opts, rollwriter.WithLogQueueSize(n))
+    }
+    if n := c.WriterConfig.QueueShards; n > 0 {
+        opts = append(opts, rollwriter.WithShards(n))
+    }
+    if n := c.WriterConfig.BatchSize; n > 0 {
+        opts = append(opts, rollwriter.WithWriteLogSize(n * 1024))
+    }
+    if n := c.WriterConfig.BatchInterval; n > 0 {
+        opts = append(opts, rollwriter.WithWriteLogInterval(n))
+    }
+    async := rollwriter.NewAsyncRollWriter(ws, opts...)
+    core := &asyncCore{
+        Core:   withFlushLevel(c, &priorityCore{LevelEnabler: enabler, enc: newEncoder(c), out: async}),
+        async:  async,
+        name:   name,
+        stopCh: make(chan struct{}),
+        doneCh: make(chan struct{}),
+    }
+    if interval := statsLogInterval(c.WriterConfig.StatsLogInterval); interval > 0 {
+        go core.runStatsLog(interval)
+    } else {
+        close(core.doneCh)
+    }
+    return core
+}
+
+// statsLogInterval returns the interval to log dropped logs, default every minute
+func statsLogInterval(n int) time.Duration {
+    if n == 0 {
+        return time.Minute
+    }
+    if n < 0 {
+        return 0
+    }
+    return time.Duration(n) * time.Second
+}
+
+// asyncCore is the core of outputs in async write modes, which reports the statistics of writing
+// and logs the number of dropped logs periodically
+type asyncCore struct {
+    zapcore.Core
+    async  *rollwriter.AsyncRollWriter
+    name   string
+    stopCh chan struct{}
+    doneCh chan struct{}
+}
+
+// Name returns the name of output. It implements StatsReporter
+func (c *asyncCore) Name() string {
+    return c.name
+}
+
+// Stats returns the statistics of writing. It implements StatsReporter
+func (c *asyncCore) Stats() config.AsyncStats {
+    s := c.async.Stats()
+    return config.AsyncStats{
+        Queued:          s.Queued,
+        Written:         s.Written,
+        Dropped:         s.Dropped,
+        Bytes:           s.Bytes,
+        QueueDepth:      s.QueueDepth,
+        Flushes:         s.Flushes,
+        FlushLatency:    s.FlushLatency,
+        MaxFlushLatency: s.MaxFlushLatency,
+        WriteErrors:     s.WriteErrors,
+        Spilled:         s.Spilled,
+        SpillBacklog:    s.SpillBacklog,
+    }
+}
+
+// SyncContext flushes the logs, returns the error of ctx if it is done before the logs are written.
+// It implements ContextSyncer
+func (c *asyncCore) SyncContext(ctx context.Context) error {
+    return c.async.SyncContext(ctx)
+}
+
+// Close stops logging the statistics, then writes the queued logs and closes the output. It implements io.Closer
+func (c *asyncCore) Close() error {
+    select {
+    case <-c.stopCh:
+    default:
+        close(c.stopCh)
+    }
+    <-c.doneCh
+    return c.async.Close()
+}
+
+// runStatsLog logs a warning every interval if any logs are dropped during it
+func (c *asyncCore) runStatsLog(interval time.Duration) {
+    defer close(c.doneCh)
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    var last uint64
+    for {
+        select {
+        case <-ticker.C:
+            s := c.async.Stats()
+            if s.Dropped == last {
+                continue
+            }
+            msg := fmt.Sprintf("zlog: %d logs dropped in last %s, %d dropped in total, queue depth %d",
+                s.Dropped-last, interval, s.Dropped, s.QueueDepth)
+            last = s.Dropped
+            _ = c.Core.Write(zapcore.Entry{Level: zapcore.WarnLevel, Time: time.Now(), Message: msg}, nil)
+        case <-c.stopCh:
+            return
+        }
+    }
+}
+
+// priorityCore works like the core of zap writing to an io, but passes the level of logs to the async writer,
+// so that warn and higher level logs are kept when the queue is full
+type priorityCore struct {
+    zapcore.LevelEnabler
+    enc zapcore.Encoder
+    out *rollwriter.AsyncRollWriter
+}
+
+// With adds structured context to the Core
+func (c *priorityCore) With(fields []zapcore.Field) zapcore.Core {
+    clone := &priorityCore{LevelEnabler: c.LevelEnabler, enc: c.enc.Clone(), out: c.out}
+    for i := range fields {
+        fields[i].AddTo(clone.enc)
+    }
+    return clone
+}
+
+// Check adds the core to the checked entry if the level is enabled
+func (c *priorityCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+    if c.Enabled(ent.Level) {
+        return ce.AddCore(ent, c)
+    }
+    return ce
+}
+
+// Write encodes and writes the log with its priority
+func (c *priorityCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+    buf, err := c.enc.EncodeEntry(ent, fields)
+    if err != nil {
+        return err
+    }
+    _, err = c.out.WritePriority(buf.Bytes(), ent.Level >= zapcore.WarnLevel)
+    buf.Free()
+    if err != nil {
+        return err
+    }
+    // sync the logs before panic or fatal exits
+    if ent.Level > zapcore.ErrorLevel {
+        _ = c.Sync()
+    }
+    return nil
+}
+
+// Sync flushes the logs
+func (c *priorityCore) Sync() error {
+    return c.out.Sync()
+}
+
+// withFlushLevel wraps the core to flush the logs at once at FlushLevel or higher, returns the core itself
+// if FlushLevel is unset
+func withFlushLevel(c *config.OutputConfig, core zapcore.Core) zapcore.Core {
+    if c.WriterConfig.FlushLevel == config.LevelNil {
+        return core
+    }
+    return &flushCore{Core: core, level: LogLevelToZapLevel[c.WriterConfig.FlushLevel]}
+}
+
+// flushCore syncs the core after writing logs at level or higher
+type flushCore struct {
+    zapcore.Core
+    level zapcore.Level
+}
+
+// With adds structured context to the Core
+func (c *flushCore) With(fields []zapcore.Field) zapcore.Core {
+    return &flushCore{Core: c.Core.With(fields), level: c.level}
+}
+
+// Check adds the core to the checked entry if the level is enabled
+func (c *flushCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+    if c.Enabled(ent.Level) {
+        return ce.AddCore(ent, c)
+    }
+    return ce
+}
+
+// Write writes the log, and flushes it at once at level or higher
+func (c *flushCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+    if err := c.Core.Write(ent, fields); err != nil {
+        return err
+    }
+    if ent.Level >= c.level {
+        return c.Sync()
+    }
+    return nil
+}
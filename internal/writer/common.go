@@ -2,6 +2,7 @@ package writer
 
 import (
     "github.com/noahyzhang/zlog/config"
+    "go.uber.org/zap"
     "go.uber.org/zap/zapcore"
     "time"
 )
@@ -97,6 +98,58 @@ func NewTimeEncoder(format string) zapcore.TimeEncoder {
     }
 }
 
+// levelFilter limits the levels of an output to a range and/or a set of levels.
+// The lower bound is an AtomicLevel, so that it can still be changed by SetLevel.
+type levelFilter struct {
+    min    zap.AtomicLevel
+    max    zapcore.Level
+    hasMax bool
+    levels map[zapcore.Level]bool
+}
+
+// Enabled checks whether the given level should be written. It implements zapcore.LevelEnabler
+func (f *levelFilter) Enabled(l zapcore.Level) bool {
+    if !f.min.Enabled(l) {
+        return false
+    }
+    if f.hasMax && l > f.max {
+        return false
+    }
+    if len(f.levels) > 0 && !f.levels[l] {
+        return false
+    }
+    return true
+}
+
+// newLevelEnabler creates the level enabler of output, and returns the AtomicLevel used as lower bound
+func newLevelEnabler(c *config.OutputConfig) (zapcore.LevelEnabler, zap.AtomicLevel) {
+    minLevel := c.Level
+    // use the lowest level of the set as lower bound if it is not configured
+    if minLevel == config.LevelNil {
+        for _, l := range c.Levels {
+            if minLevel == config.LevelNil || l < minLevel {
+                minLevel = l
+            }
+        }
+    }
+    lvl := zap.NewAtomicLevelAt(LogLevelToZapLevel[minLevel])
+    if c.MaxLevel == config.LevelNil && len(c.Levels) == 0 {
+        return lvl, lvl
+    }
+    f := &levelFilter{min: lvl}
+    if c.MaxLevel != config.LevelNil {
+        f.max = LogLevelToZapLevel[c.MaxLevel]
+        f.hasMax = true
+    }
+    if len(c.Levels) > 0 {
+        f.levels = make(map[zapcore.Level]bool, len(c.Levels))
+        for _, l := range c.Levels {
+            f.levels[LogLevelToZapLevel[l]] = true
+        }
+    }
+    return f, lvl
+}
+
 func newEncoder(c *config.OutputConfig) zapcore.Encoder {
     encoderCfg := zapcore.EncoderConfig{
         TimeKey:        GetLogEncoderKey("T", c.FormatConfig.TimeKey),
@@ -117,6 +170,16 @@ func newEncoder(c *config.OutputConfig) zapcore.Encoder {
         return zapcore.NewConsoleEncoder(encoderCfg)
     case config.FormatterJson:
         return zapcore.NewJSONEncoder(encoderCfg)
+    case config.FormatterLogfmt:
+        // conventional keys and lowercase levels of logfmt
+        encoderCfg.TimeKey = GetLogEncoderKey("time", c.FormatConfig.TimeKey)
+        encoderCfg.LevelKey = GetLogEncoderKey("level", c.FormatConfig.LevelKey)
+        encoderCfg.NameKey = GetLogEncoderKey("logger", c.FormatConfig.NameKey)
+        encoderCfg.CallerKey = GetLogEncoderKey("caller", c.FormatConfig.CallerKey)
+        encoderCfg.MessageKey = GetLogEncoderKey("msg", c.FormatConfig.MessageKey)
+        encoderCfg.StacktraceKey = GetLogEncoderKey("stacktrace", c.FormatConfig.StacktraceKey)
+        encoderCfg.EncodeLevel = zapcore.LowercaseLevelEncoder
+        return NewLogfmtEncoder(encoderCfg)
     default:
         return zapcore.NewConsoleEncoder(encoderCfg)
     }
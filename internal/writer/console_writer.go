@@ -15,8 +15,15 @@ type ConsoleWriterFactory struct {
 }
 
 func (f *ConsoleWriterFactory) Setup(c *config.OutputConfig) (zapcore.Core, zap.AtomicLevel, error)  {
-    lvl := zap.NewAtomicLevelAt(LogLevelToZapLevel[c.Level])
-    return zapcore.NewCore(newEncoder(c), zapcore.Lock(os.Stdout), lvl), lvl, nil
+    ws, err := f.WriteSyncer(c)
+    if err != nil {
+        return nil, zap.AtomicLevel{}, err
+    }
+    core, lvl := NewCore(c, ws)
+    return core, lvl, nil
 }
 
-
+// WriteSyncer returns the stdout. It implements WriteSyncerFactory
+func (f *ConsoleWriterFactory) WriteSyncer(c *config.OutputConfig) (zapcore.WriteSyncer, error) {
+    return zapcore.Lock(os.Stdout), nil
+}
@@ -1,6 +1,8 @@
 package writer
 
 import (
+    "context"
+
     "github.com/noahyzhang/zlog/config"
     "go.uber.org/zap"
     "go.uber.org/zap/zapcore"
@@ -24,3 +26,39 @@ type Factory interface {
     Setup(c *config.OutputConfig) (zapcore.Core, zap.AtomicLevel, error)
 }
 
+// WriteSyncerFactory is implemented by the output factories which only provide where the encoded logs go.
+// The logger builds the cores of such outputs by NewCore instead of Setup, so that all the write modes apply
+type WriteSyncerFactory interface {
+    // WriteSyncer creates the writer of the output
+    WriteSyncer(c *config.OutputConfig) (zapcore.WriteSyncer, error)
+}
+
+// Rotator is implemented by the cores of outputs writing files, to rotate or reopen files manually
+type Rotator interface {
+    // Rotate backs the current log file up and opens a new one
+    Rotate() error
+    // Reopen reopens the current log file, e.g. after it is moved by external logrotate
+    Reopen() error
+}
+
+// Planner is implemented by the cores of outputs writing files, to preview the retention sweeps
+type Planner interface {
+    // FilePath returns the path of the log file
+    FilePath() string
+    // Plan returns the files which the retention sweep would compress or delete now, without touching them
+    Plan() (config.CleanPlan, error)
+}
+
+// StatsReporter is implemented by the cores of outputs in async write modes, to report the statistics
+type StatsReporter interface {
+    // Name returns the name of the output, like the file path
+    Name() string
+    // Stats returns the statistics of writing
+    Stats() config.AsyncStats
+}
+
+// ContextSyncer is implemented by the cores of outputs in async write modes, to flush the logs with a deadline
+type ContextSyncer interface {
+    // SyncContext flushes the logs, returns the error of ctx if it is done before the logs are written
+    SyncContext(ctx context.Context) error
+}
@@ -1,12 +1,22 @@
 package writer
 
 import (
+    "fmt"
     "github.com/noahyzhang/zlog/config"
     "github.com/noahyzhang/zlog/internal/rollwriter"
     "go.uber.org/zap"
     "go.uber.org/zap/zapcore"
+    "time"
 )
 
+// fsyncPolicies maps the config fsync policy to rollwriter
+var fsyncPolicies = map[config.FsyncPolicyType]rollwriter.FsyncPolicy{
+    config.FsyncNever:         rollwriter.FsyncNever,
+    config.FsyncOnSync:        rollwriter.FsyncOnSync,
+    config.FsyncEveryInterval: rollwriter.FsyncInterval,
+    config.FsyncEveryWrite:    rollwriter.FsyncAlways,
+}
+
 // DefaultFileWriterFactory is the default file output implementation
 var DefaultFileWriterFactory = &FileWriterFactory{}
 
@@ -15,29 +25,196 @@ type FileWriterFactory struct {
 }
 
 func (f *FileWriterFactory) Setup(c *config.OutputConfig) (zapcore.Core, zap.AtomicLevel, error) {
+    codec, err := newCodec(&c.WriterConfig)
+    if err != nil {
+        return nil, zap.AtomicLevel{}, err
+    }
     opts := []rollwriter.Option{
         rollwriter.WithMaxAge(c.WriterConfig.MaxAge),
         rollwriter.WithMaxBackups(c.WriterConfig.MaxBackups),
         rollwriter.WithCompress(c.WriterConfig.Compress),
+        rollwriter.WithCleanInterval(cleanInterval(c.WriterConfig.CleanInterval)),
+        rollwriter.WithCodec(codec),
+        rollwriter.WithCompressConcurrency(c.WriterConfig.CompressConcurrency),
+        rollwriter.WithCompressRateLimit(c.WriterConfig.CompressRateLimit),
         rollwriter.WithMaxSize(c.WriterConfig.MaxSize),
+        rollwriter.WithMaxTotalSize(c.WriterConfig.MaxTotalSize),
+        rollwriter.WithMinFreeDisk(c.WriterConfig.MinFreeDisk),
+        rollwriter.WithNumberedBackup(c.WriterConfig.BackupNaming == config.BackupNameNumber),
+        rollwriter.WithBackupTimeFormat(c.WriterConfig.BackupTimeFmt),
+        rollwriter.WithBackupUTC(c.WriterConfig.BackupUTC),
+        rollwriter.WithSymlink(c.WriterConfig.Symlink),
+        rollwriter.WithStatInterval(c.WriterConfig.StatInterval),
+        rollwriter.WithArchiveDir(c.WriterConfig.ArchiveDir),
+        rollwriter.WithArchiveLayout(c.WriterConfig.ArchiveLayout),
+        rollwriter.WithFileMode(c.WriterConfig.FileMode),
+        rollwriter.WithDirMode(c.WriterConfig.DirMode),
+        rollwriter.WithOwner(c.WriterConfig.Uid, c.WriterConfig.Gid),
+        rollwriter.WithFsyncPolicy(fsyncPolicies[c.WriterConfig.FsyncPolicy], fsyncInterval(c.WriterConfig.FsyncInterval)),
+    }
+    if h := c.WriterConfig.OnFileEvent; h != nil {
+        opts = append(opts, rollwriter.WithEventHandler(func(e rollwriter.Event) {
+            h(config.FileEvent{Type: config.FileEventType(e.Type), Path: e.Path, OldPath: e.OldPath,
+                CurrPath: e.CurrPath, Time: e.Time})
+        }))
     }
     // roll by time
     if c.WriterConfig.RollType != config.RollBySize {
-        opts = append(opts, rollwriter.WithRotationTime(c.WriterConfig.TimeUnit.Format()))
+        loc := time.Local
+        if c.WriterConfig.TimeZone != "" {
+            l, err := time.LoadLocation(c.WriterConfig.TimeZone)
+            if err != nil {
+                return nil, zap.AtomicLevel{}, err
+            }
+            loc = l
+        }
+        unit, n := c.WriterConfig.TimeUnit, c.WriterConfig.TimeInterval
+        opts = append(opts,
+            rollwriter.WithRotationTime(unit.Format()),
+            rollwriter.WithLocation(loc),
+            rollwriter.WithRotationPeriod(func(t time.Time) (time.Time, time.Time) {
+                return unit.Truncate(t, n), unit.Next(t, n)
+            }))
     }
     writer, err := rollwriter.NewRollWriter(c.WriterConfig.FileName, opts...)
     if err != nil {
         return nil, zap.AtomicLevel{}, err
     }
+    // log level
+    enabler, lvl := newLevelEnabler(c)
+    // drop unimportant logs when disk quota is exceeded
+    if c.WriterConfig.MaxTotalSize > 0 || c.WriterConfig.MinFreeDisk > 0 {
+        enabler = &degradedFilter{LevelEnabler: enabler, writer: writer}
+    }
     // write mod
-    var ws zapcore.WriteSyncer
-    if c.WriterConfig.WriteMode == config.WriteSync {
-        ws = zapcore.AddSync(writer)
-    } else {
-        dropLog := c.WriterConfig.WriteMode == config.WriteFast
-        ws = rollwriter.NewAsyncRollWriter(writer, rollwriter.WithDropLog(dropLog))
+    if isAsync(c, true) {
+        return &asyncFileCore{asyncCore: newAsyncCore(c, writer, enabler, c.WriterConfig.FileName),
+            rotator: rotator{writer: writer, path: c.WriterConfig.FileName}}, lvl, nil
+    }
+    var ws zapcore.WriteSyncer = writer
+    if c.WriterConfig.BufferSize > 0 {
+        flushInterval := c.WriterConfig.FlushInterval
+        if flushInterval <= 0 {
+            flushInterval = 1000
+        }
+        ws = &zapcore.BufferedWriteSyncer{
+            WS:            writer,
+            Size:          c.WriterConfig.BufferSize * 1024,
+            FlushInterval: time.Duration(flushInterval) * time.Millisecond,
+        }
+    }
+    return &fileCore{Core: withFlushLevel(c, zapcore.NewCore(newEncoder(c), ws, enabler)),
+        rotator: rotator{writer: writer, path: c.WriterConfig.FileName}, ws: ws}, lvl, nil
+}
+
+// cleanInterval returns the interval(seconds) of retention sweeps, default every hour
+func cleanInterval(n int) int {
+    if n == 0 {
+        return 3600
+    }
+    if n < 0 {
+        return 0
+    }
+    return n
+}
+
+// fsyncInterval returns the interval(ms) to commit log file to disk, default every second
+func fsyncInterval(n int) int {
+    if n <= 0 {
+        return 1000
+    }
+    return n
+}
+
+// rotator rotates and reopens the log file manually, and previews its retention sweeps
+type rotator struct {
+    writer *rollwriter.RollWriter
+    path string
+}
+
+// Rotate backs the current log file up and opens a new one. It implements Rotator
+func (r rotator) Rotate() error {
+    return r.writer.Rotate()
+}
+
+// Reopen reopens the current log file. It implements Rotator
+func (r rotator) Reopen() error {
+    return r.writer.Reopen()
+}
+
+// FilePath returns the path of the log file. It implements Planner
+func (r rotator) FilePath() string {
+    return r.path
+}
+
+// Plan returns the files which the retention sweep would compress or delete now. It implements Planner
+func (r rotator) Plan() (config.CleanPlan, error) {
+    plan, err := r.writer.Plan()
+    if err != nil {
+        return config.CleanPlan{}, err
+    }
+    return config.CleanPlan{Compress: plan.Compress, Remove: plan.Remove}, nil
+}
+
+// fileCore is the core of file output in sync write mode, which supports rotating and reopening files manually
+type fileCore struct {
+    zapcore.Core
+    rotator
+    ws zapcore.WriteSyncer
+}
+
+// Close flushes the buffered logs, closes the log file and stops all the background goroutines.
+// It implements io.Closer
+func (c *fileCore) Close() error {
+    if ws, ok := c.ws.(*zapcore.BufferedWriteSyncer); ok {
+        _ = ws.Stop()
+    }
+    return c.writer.Close()
+}
+
+// asyncFileCore is the core of file output in async write modes
+type asyncFileCore struct {
+    *asyncCore
+    rotator
+}
+
+// degradedFilter only enables error and higher level logs when the writer is degraded
+type degradedFilter struct {
+    zapcore.LevelEnabler
+    writer *rollwriter.RollWriter
+}
+
+// Enabled checks whether the given level should be written. It implements zapcore.LevelEnabler
+func (f *degradedFilter) Enabled(l zapcore.Level) bool {
+    if !f.LevelEnabler.Enabled(l) {
+        return false
+    }
+    return l >= zapcore.ErrorLevel || !f.writer.Degraded()
+}
+
+// newCodec creates the compression codec of log files, gzip as default.
+// It returns an error if the compression level is out of the range of codec
+func newCodec(c *config.WriteConfig) (rollwriter.Codec, error) {
+    switch c.CompressCodec {
+    case config.CompressZstd:
+        if c.CompressLevel < 0 || c.CompressLevel > 22 {
+            return nil, fmt.Errorf("invalid zstd compress level %d, should be between 1 and 22", c.CompressLevel)
+        }
+        return rollwriter.NewZstdCodec(c.CompressLevel), nil
+    case config.CompressLz4:
+        if c.CompressLevel < 0 || c.CompressLevel > 9 {
+            return nil, fmt.Errorf("invalid lz4 compress level %d, should be between 1 and 9", c.CompressLevel)
+        }
+        return rollwriter.NewLz4Codec(c.CompressLevel), nil
+    case config.CompressSnappy:
+        if c.CompressLevel != 0 {
+            return nil, fmt.Errorf("invalid snappy compress level %d, snappy has no levels", c.CompressLevel)
+        }
+        return rollwriter.NewSnappyCodec(), nil
+    default:
+        if c.CompressLevel < 0 || c.CompressLevel > 9 {
+            return nil, fmt.Errorf("invalid gzip compress level %d, should be between 1 and 9", c.CompressLevel)
+        }
+        return rollwriter.NewGzipCodec(c.CompressLevel), nil
     }
-    // log level
-    lvl := zap.NewAtomicLevelAt(LogLevelToZapLevel[c.Level])
-    return zapcore.NewCore(newEncoder(c), ws, lvl), lvl, nil
 }
@@ -0,0 +1,49 @@
+package writer
+
+import (
+    "path/filepath"
+    "testing"
+
+    "github.com/noahyzhang/zlog/config"
+)
+
+func TestSetupCompressLevel(t *testing.T) {
+    tests := []struct {
+        codec config.CompressCodecType
+        level int
+        ok bool
+    }{
+        {0, 0, true},
+        {config.CompressGzip, 9, true},
+        {config.CompressGzip, 10, false},
+        {config.CompressGzip, -1, false},
+        {config.CompressZstd, 22, true},
+        {config.CompressZstd, 23, false},
+        {config.CompressLz4, 9, true},
+        {config.CompressLz4, 10, false},
+        {config.CompressSnappy, 0, true},
+        {config.CompressSnappy, 1, false},
+    }
+    for _, tt := range tests {
+        c := &config.OutputConfig{
+            WriterName: config.OutputFile,
+            WriterConfig: config.WriteConfig{
+                FileName: filepath.Join(t.TempDir(), "test.log"),
+                RollType: config.RollBySize,
+                WriteMode: config.WriteSync,
+                Compress: true,
+                CompressCodec: tt.codec,
+                CompressLevel: tt.level,
+            },
+            Formatter: config.FormatterJson,
+            Level: config.LevelDebug,
+        }
+        core, _, err := DefaultFileWriterFactory.Setup(c)
+        if (err == nil) != tt.ok {
+            t.Errorf("Setup with codec %d level %d: err = %v, want ok %v", tt.codec, tt.level, err, tt.ok)
+        }
+        if cl, ok := core.(interface{ Close() error }); ok {
+            _ = cl.Close()
+        }
+    }
+}
@@ -0,0 +1,513 @@
+package writer
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "math"
+    "sort"
+    "strconv"
+    "time"
+    "unicode/utf8"
+
+    "go.uber.org/zap/buffer"
+    "go.uber.org/zap/zapcore"
+)
+
+const hex = "0123456789abcdef"
+
+var logfmtPool = buffer.NewPool()
+
+// logfmtEncoder encodes logs in logfmt, like `time=... level=info msg="hello world" user.id=1`.
+// Values are quoted if needed, nested objects and arrays are flattened with dotted keys.
+type logfmtEncoder struct {
+    *zapcore.EncoderConfig
+    buf *buffer.Buffer
+    // prefix is the key prefix of opened namespaces and objects, like "user."
+    prefix string
+}
+
+// NewLogfmtEncoder creates a logfmt encoder
+func NewLogfmtEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+    return &logfmtEncoder{EncoderConfig: &cfg, buf: logfmtPool.Get()}
+}
+
+// AddArray flattens the array with keys like key.0, key.1
+func (enc *logfmtEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+    return arr.MarshalLogArray(&logfmtArrayEncoder{enc: enc, key: enc.prefix + key})
+}
+
+// AddObject flattens the object with keys like key.field
+func (enc *logfmtEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+    return enc.withPrefix(enc.prefix+key+".", obj)
+}
+
+// withPrefix encodes the object with the key prefix
+func (enc *logfmtEncoder) withPrefix(prefix string, obj zapcore.ObjectMarshaler) error {
+    old := enc.prefix
+    enc.prefix = prefix
+    err := obj.MarshalLogObject(enc)
+    enc.prefix = old
+    return err
+}
+
+func (enc *logfmtEncoder) AddBinary(key string, val []byte) {
+    enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *logfmtEncoder) AddByteString(key string, val []byte) {
+    enc.addKey(key)
+    enc.appendValue(string(val))
+}
+
+func (enc *logfmtEncoder) AddBool(key string, val bool) {
+    enc.addKey(key)
+    enc.buf.AppendBool(val)
+}
+
+func (enc *logfmtEncoder) AddComplex128(key string, val complex128) {
+    enc.addKey(key)
+    enc.appendComplex(val, 64)
+}
+
+func (enc *logfmtEncoder) AddComplex64(key string, val complex64) {
+    enc.addKey(key)
+    enc.appendComplex(complex128(val), 32)
+}
+
+func (enc *logfmtEncoder) AddDuration(key string, val time.Duration) {
+    enc.addKey(key)
+    enc.appendDuration(val)
+}
+
+func (enc *logfmtEncoder) AddFloat64(key string, val float64) {
+    enc.addKey(key)
+    enc.appendFloat(val, 64)
+}
+
+func (enc *logfmtEncoder) AddFloat32(key string, val float32) {
+    enc.addKey(key)
+    enc.appendFloat(float64(val), 32)
+}
+
+func (enc *logfmtEncoder) AddInt(key string, val int)     { enc.AddInt64(key, int64(val)) }
+func (enc *logfmtEncoder) AddInt32(key string, val int32) { enc.AddInt64(key, int64(val)) }
+func (enc *logfmtEncoder) AddInt16(key string, val int16) { enc.AddInt64(key, int64(val)) }
+func (enc *logfmtEncoder) AddInt8(key string, val int8)   { enc.AddInt64(key, int64(val)) }
+
+func (enc *logfmtEncoder) AddInt64(key string, val int64) {
+    enc.addKey(key)
+    enc.buf.AppendInt(val)
+}
+
+func (enc *logfmtEncoder) AddString(key, val string) {
+    enc.addKey(key)
+    enc.appendValue(val)
+}
+
+func (enc *logfmtEncoder) AddTime(key string, val time.Time) {
+    enc.addKey(key)
+    enc.appendTime(val)
+}
+
+func (enc *logfmtEncoder) AddUint(key string, val uint)       { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUint32(key string, val uint32)   { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUint16(key string, val uint16)   { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUint8(key string, val uint8)     { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUintptr(key string, val uintptr) { enc.AddUint64(key, uint64(val)) }
+
+func (enc *logfmtEncoder) AddUint64(key string, val uint64) {
+    enc.addKey(key)
+    enc.buf.AppendUint(val)
+}
+
+// AddReflected flattens the value by its json form with keys like key.field and key.0
+func (enc *logfmtEncoder) AddReflected(key string, val interface{}) error {
+    v, err := decodeReflected(val)
+    if err != nil {
+        return err
+    }
+    enc.addFlattened(enc.prefix+key, v)
+    return nil
+}
+
+// decodeReflected marshals the value in json and decodes it into maps, slices and scalars
+func decodeReflected(val interface{}) (interface{}, error) {
+    b, err := json.Marshal(val)
+    if err != nil {
+        return nil, err
+    }
+    dec := json.NewDecoder(bytes.NewReader(b))
+    // keep the numbers as they are marshaled
+    dec.UseNumber()
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return nil, err
+    }
+    return v, nil
+}
+
+// addFlattened writes the decoded json value with the key, flattening objects by sorted fields and arrays
+// by indexes. Empty objects and arrays are written as {} and []
+func (enc *logfmtEncoder) addFlattened(key string, v interface{}) {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        if len(v) == 0 {
+            enc.addRawKey(key)
+            enc.buf.AppendString("{}")
+            return
+        }
+        keys := make([]string, 0, len(v))
+        for k := range v {
+            keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        for _, k := range keys {
+            enc.addFlattened(key+"."+k, v[k])
+        }
+    case []interface{}:
+        if len(v) == 0 {
+            enc.addRawKey(key)
+            enc.buf.AppendString("[]")
+            return
+        }
+        for i, e := range v {
+            enc.addFlattened(key+"."+strconv.Itoa(i), e)
+        }
+    case string:
+        enc.addRawKey(key)
+        enc.appendValue(v)
+    case json.Number:
+        enc.addRawKey(key)
+        enc.buf.AppendString(v.String())
+    case bool:
+        enc.addRawKey(key)
+        enc.buf.AppendBool(v)
+    default:
+        enc.addRawKey(key)
+        enc.buf.AppendString("null")
+    }
+}
+
+// OpenNamespace prefixes the keys of following fields with key
+func (enc *logfmtEncoder) OpenNamespace(key string) {
+    enc.prefix += key + "."
+}
+
+// Clone copies the encoder with the fields added
+func (enc *logfmtEncoder) Clone() zapcore.Encoder {
+    clone := &logfmtEncoder{EncoderConfig: enc.EncoderConfig, buf: logfmtPool.Get(), prefix: enc.prefix}
+    _, _ = clone.buf.Write(enc.buf.Bytes())
+    return clone
+}
+
+// EncodeEntry encodes the entry and fields into a line
+func (enc *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+    final := &logfmtEncoder{EncoderConfig: enc.EncoderConfig, buf: logfmtPool.Get()}
+    if final.TimeKey != "" {
+        final.AddTime(final.TimeKey, ent.Time)
+    }
+    if final.LevelKey != "" {
+        final.addKey(final.LevelKey)
+        cur := final.buf.Len()
+        if final.EncodeLevel != nil {
+            final.EncodeLevel(ent.Level, &logfmtArrayEncoder{enc: final})
+        }
+        if cur == final.buf.Len() {
+            final.appendValue(ent.Level.String())
+        }
+    }
+    if ent.LoggerName != "" && final.NameKey != "" {
+        final.addKey(final.NameKey)
+        cur := final.buf.Len()
+        if final.EncodeName != nil {
+            final.EncodeName(ent.LoggerName, &logfmtArrayEncoder{enc: final})
+        }
+        if cur == final.buf.Len() {
+            final.appendValue(ent.LoggerName)
+        }
+    }
+    if ent.Caller.Defined {
+        if final.CallerKey != "" {
+            final.addKey(final.CallerKey)
+            cur := final.buf.Len()
+            if final.EncodeCaller != nil {
+                final.EncodeCaller(ent.Caller, &logfmtArrayEncoder{enc: final})
+            }
+            if cur == final.buf.Len() {
+                final.appendValue(ent.Caller.String())
+            }
+        }
+        if final.FunctionKey != "" {
+            final.AddString(final.FunctionKey, ent.Caller.Function)
+        }
+    }
+    if final.MessageKey != "" {
+        final.AddString(final.MessageKey, ent.Message)
+    }
+    // the fields added by With
+    if enc.buf.Len() > 0 {
+        if final.buf.Len() > 0 {
+            final.buf.AppendByte(' ')
+        }
+        _, _ = final.buf.Write(enc.buf.Bytes())
+    }
+    final.prefix = enc.prefix
+    for i := range fields {
+        fields[i].AddTo(final)
+    }
+    final.prefix = ""
+    if ent.Stack != "" && final.StacktraceKey != "" {
+        final.AddString(final.StacktraceKey, ent.Stack)
+    }
+    if final.LineEnding != "" {
+        final.buf.AppendString(final.LineEnding)
+    } else {
+        final.buf.AppendString(zapcore.DefaultLineEnding)
+    }
+    ret := final.buf
+    final.buf = nil
+    return ret, nil
+}
+
+// addKey writes the key with the prefix, separated by a space from the former one
+func (enc *logfmtEncoder) addKey(key string) {
+    enc.addRawKey(enc.prefix + key)
+}
+
+// addRawKey writes the key, replacing the characters not allowed in keys with '_'
+func (enc *logfmtEncoder) addRawKey(key string) {
+    if enc.buf.Len() > 0 {
+        enc.buf.AppendByte(' ')
+    }
+    if key == "" {
+        key = "_"
+    }
+    for i := 0; i < len(key); {
+        r, size := utf8.DecodeRuneInString(key[i:])
+        if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+            enc.buf.AppendByte('_')
+        } else {
+            enc.buf.AppendString(key[i : i+size])
+        }
+        i += size
+    }
+    enc.buf.AppendByte('=')
+}
+
+// appendValue writes the string value, quoted if it is empty or contains spaces, '=', '"' or control characters
+func (enc *logfmtEncoder) appendValue(s string) {
+    if !needsQuote(s) {
+        enc.buf.AppendString(s)
+        return
+    }
+    enc.buf.AppendByte('"')
+    for i := 0; i < len(s); {
+        b := s[i]
+        if b < utf8.RuneSelf {
+            switch {
+            case b == '\\' || b == '"':
+                enc.buf.AppendByte('\\')
+                enc.buf.AppendByte(b)
+            case b == '\n':
+                enc.buf.AppendString(`\n`)
+            case b == '\r':
+                enc.buf.AppendString(`\r`)
+            case b == '\t':
+                enc.buf.AppendString(`\t`)
+            case b < 0x20:
+                enc.buf.AppendString(`\u00`)
+                enc.buf.AppendByte(hex[b>>4])
+                enc.buf.AppendByte(hex[b&0xF])
+            default:
+                enc.buf.AppendByte(b)
+            }
+            i++
+            continue
+        }
+        r, size := utf8.DecodeRuneInString(s[i:])
+        if r == utf8.RuneError && size == 1 {
+            enc.buf.AppendString("\ufffd")
+        } else {
+            enc.buf.AppendString(s[i : i+size])
+        }
+        i += size
+    }
+    enc.buf.AppendByte('"')
+}
+
+// needsQuote checks whether the value should be quoted
+func needsQuote(s string) bool {
+    if s == "" {
+        return true
+    }
+    for _, r := range s {
+        if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || r == 0x7f {
+            return true
+        }
+    }
+    return false
+}
+
+func (enc *logfmtEncoder) appendFloat(val float64, bitSize int) {
+    switch {
+    case math.IsNaN(val):
+        enc.buf.AppendString("NaN")
+    case math.IsInf(val, 1):
+        enc.buf.AppendString("+Inf")
+    case math.IsInf(val, -1):
+        enc.buf.AppendString("-Inf")
+    default:
+        enc.buf.AppendFloat(val, bitSize)
+    }
+}
+
+func (enc *logfmtEncoder) appendComplex(val complex128, bitSize int) {
+    enc.buf.AppendString(strconv.FormatComplex(val, 'f', -1, bitSize*2))
+}
+
+// appendDuration writes the duration by EncodeDuration, nanoseconds as default
+func (enc *logfmtEncoder) appendDuration(val time.Duration) {
+    cur := enc.buf.Len()
+    if enc.EncodeDuration != nil {
+        enc.EncodeDuration(val, &logfmtArrayEncoder{enc: enc})
+    }
+    if cur == enc.buf.Len() {
+        enc.buf.AppendInt(int64(val))
+    }
+}
+
+// appendTime writes the time by EncodeTime, RFC3339 as default
+func (enc *logfmtEncoder) appendTime(val time.Time) {
+    cur := enc.buf.Len()
+    if enc.EncodeTime != nil {
+        enc.EncodeTime(val, &logfmtArrayEncoder{enc: enc})
+    }
+    if cur == enc.buf.Len() {
+        enc.buf.AppendTime(val, time.RFC3339Nano)
+    }
+}
+
+// logfmtArrayEncoder flattens arrays with keys like key.0, key.1. With empty key, it writes the values of
+// the key already written, for the encoders of time, level, caller and so on.
+type logfmtArrayEncoder struct {
+    enc *logfmtEncoder
+    key string
+    n   int
+}
+
+// next writes the key of next element
+func (a *logfmtArrayEncoder) next() {
+    if a.key != "" {
+        a.enc.addRawKey(a.elemKey())
+    }
+    a.n++
+}
+
+// elemKey returns the key of current element
+func (a *logfmtArrayEncoder) elemKey() string {
+    return a.key + "." + strconv.Itoa(a.n)
+}
+
+func (a *logfmtArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+    if a.key == "" {
+        return nil
+    }
+    elem := &logfmtArrayEncoder{enc: a.enc, key: a.elemKey()}
+    a.n++
+    return arr.MarshalLogArray(elem)
+}
+
+func (a *logfmtArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+    if a.key == "" {
+        return nil
+    }
+    prefix := a.elemKey() + "."
+    a.n++
+    return a.enc.withPrefix(prefix, obj)
+}
+
+func (a *logfmtArrayEncoder) AppendReflected(val interface{}) error {
+    if a.key == "" {
+        b, err := json.Marshal(val)
+        if err != nil {
+            return err
+        }
+        a.AppendString(string(b))
+        return nil
+    }
+    v, err := decodeReflected(val)
+    if err != nil {
+        return err
+    }
+    key := a.elemKey()
+    a.n++
+    a.enc.addFlattened(key, v)
+    return nil
+}
+
+func (a *logfmtArrayEncoder) AppendBool(val bool) {
+    a.next()
+    a.enc.buf.AppendBool(val)
+}
+
+func (a *logfmtArrayEncoder) AppendByteString(val []byte) {
+    a.next()
+    a.enc.appendValue(string(val))
+}
+
+func (a *logfmtArrayEncoder) AppendComplex128(val complex128) {
+    a.next()
+    a.enc.appendComplex(val, 64)
+}
+
+func (a *logfmtArrayEncoder) AppendComplex64(val complex64) {
+    a.next()
+    a.enc.appendComplex(complex128(val), 32)
+}
+
+func (a *logfmtArrayEncoder) AppendFloat64(val float64) {
+    a.next()
+    a.enc.appendFloat(val, 64)
+}
+
+func (a *logfmtArrayEncoder) AppendFloat32(val float32) {
+    a.next()
+    a.enc.appendFloat(float64(val), 32)
+}
+
+func (a *logfmtArrayEncoder) AppendInt(val int)     { a.AppendInt64(int64(val)) }
+func (a *logfmtArrayEncoder) AppendInt32(val int32) { a.AppendInt64(int64(val)) }
+func (a *logfmtArrayEncoder) AppendInt16(val int16) { a.AppendInt64(int64(val)) }
+func (a *logfmtArrayEncoder) AppendInt8(val int8)   { a.AppendInt64(int64(val)) }
+
+func (a *logfmtArrayEncoder) AppendInt64(val int64) {
+    a.next()
+    a.enc.buf.AppendInt(val)
+}
+
+func (a *logfmtArrayEncoder) AppendString(val string) {
+    a.next()
+    a.enc.appendValue(val)
+}
+
+func (a *logfmtArrayEncoder) AppendUint(val uint)       { a.AppendUint64(uint64(val)) }
+func (a *logfmtArrayEncoder) AppendUint32(val uint32)   { a.AppendUint64(uint64(val)) }
+func (a *logfmtArrayEncoder) AppendUint16(val uint16)   { a.AppendUint64(uint64(val)) }
+func (a *logfmtArrayEncoder) AppendUint8(val uint8)     { a.AppendUint64(uint64(val)) }
+func (a *logfmtArrayEncoder) AppendUintptr(val uintptr) { a.AppendUint64(uint64(val)) }
+
+func (a *logfmtArrayEncoder) AppendUint64(val uint64) {
+    a.next()
+    a.enc.buf.AppendUint(val)
+}
+
+func (a *logfmtArrayEncoder) AppendDuration(val time.Duration) {
+    a.next()
+    a.enc.appendDuration(val)
+}
+
+func (a *logfmtArrayEncoder) AppendTime(val time.Time) {
+    a.next()
+    a.enc.appendTime(val)
+}
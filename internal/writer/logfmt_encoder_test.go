@@ -0,0 +1,81 @@
+package writer
+
+import (
+    "testing"
+
+    "go.uber.org/zap"
+    "go.uber.org/zap/zapcore"
+)
+
+// user is an object field flattened by AddObject
+type user struct {
+    id int
+    name string
+}
+
+func (u user) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+    enc.AddInt("id", u.id)
+    enc.AddString("name", u.name)
+    return nil
+}
+
+func TestLogfmtEncoder(t *testing.T) {
+    tests := []struct {
+        desc string
+        fields []zapcore.Field
+        want string
+    }{
+        {"plain", []zapcore.Field{zap.String("k", "v"), zap.Int("n", 1), zap.Bool("ok", true)}, "k=v n=1 ok=true"},
+        {"quoted", []zapcore.Field{zap.String("k", "a b"), zap.String("e", ""), zap.String("eq", "a=b")},
+            `k="a b" e="" eq="a=b"`},
+        {"escaped", []zapcore.Field{zap.String("k", "say \"hi\"\n\t\\ \x01")}, `k="say \"hi\"\n\t\\ \u0001"`},
+        {"invalid utf8", []zapcore.Field{zap.String("k", "a\xffb")}, "k=\"a�b\""},
+        {"bad key", []zapcore.Field{zap.String("a b=\"c\"", "v"), zap.String("", "v")}, "a_b__c_=v _=v"},
+        {"object", []zapcore.Field{zap.Object("user", user{id: 1, name: "a b"})}, `user.id=1 user.name="a b"`},
+        {"array", []zapcore.Field{zap.Strings("tags", []string{"a", "b c"})}, `tags.0=a tags.1="b c"`},
+        {"namespace", []zapcore.Field{zap.Namespace("req"), zap.Int("id", 2)}, "req.id=2"},
+        {"reflected map", []zapcore.Field{zap.Any("obj", map[string]interface{}{"a b": 1, "c": "x y"})},
+            `obj.a_b=1 obj.c="x y"`},
+        {"reflected struct", []zapcore.Field{zap.Reflect("obj", struct {
+            Name string
+            Tags []string
+            Meta map[string]interface{}
+            Ptr *int
+        }{Name: "n", Tags: []string{"a", "b"}, Meta: map[string]interface{}{"x": 1.5, "y": []int{}}})},
+            "obj.Meta.x=1.5 obj.Meta.y=[] obj.Name=n obj.Ptr=null obj.Tags.0=a obj.Tags.1=b"},
+        {"reflected scalar", []zapcore.Field{zap.Reflect("n", 12345678901234567), zap.Reflect("s", "a b")},
+            `n=12345678901234567 s="a b"`},
+        {"reflected empty", []zapcore.Field{zap.Reflect("m", map[string]int{}), zap.Reflect("l", []int{})},
+            "m={} l=[]"},
+        {"reflected in array", []zapcore.Field{zap.Array("arr", zapcore.ArrayMarshalerFunc(
+            func(enc zapcore.ArrayEncoder) error {
+                enc.AppendInt(1)
+                return enc.AppendReflected(map[string]int{"a": 2})
+            }))}, "arr.0=1 arr.1.a=2"},
+    }
+    for _, tt := range tests {
+        enc := NewLogfmtEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+        buf, err := enc.EncodeEntry(zapcore.Entry{Message: "hello"}, tt.fields)
+        if err != nil {
+            t.Errorf("%s: EncodeEntry: %v", tt.desc, err)
+            continue
+        }
+        if got, want := buf.String(), "msg=hello "+tt.want+"\n"; got != want {
+            t.Errorf("%s: got %q, want %q", tt.desc, got, want)
+        }
+        buf.Free()
+    }
+}
+
+func TestLogfmtEncoderWith(t *testing.T) {
+    enc := NewLogfmtEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+    zap.String("uid", "1").AddTo(enc)
+    zap.Namespace("req").AddTo(enc)
+    buf, err := enc.Clone().EncodeEntry(zapcore.Entry{Message: "a b"}, []zapcore.Field{zap.Int("id", 2)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, want := buf.String(), "msg=\"a b\" uid=1 req.id=2\n"; got != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+}
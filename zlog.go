@@ -1,6 +1,13 @@
 package zlog
 
 import (
+    "context"
+    "expvar"
+    "io"
+    "os"
+    "os/signal"
+    "syscall"
+
     "github.com/noahyzhang/zlog/config"
     "github.com/noahyzhang/zlog/internal/logger"
     "github.com/noahyzhang/zlog/internal/writer"
@@ -90,7 +97,90 @@ func Fatalf(format string, args ...interface{}) {
     logger.GetDefaultLogger().Fatalf(format, args...)
 }
 
-// Sync writes logs that are still in the cache to disk
+// Sync writes logs that are still in the cache to disk. In async write modes, it blocks until all the logs
+// written before are written to files, and returns the write errors
 func Sync() error {
     return logger.GetDefaultLogger().Sync()
-}
\ No newline at end of file
+}
+
+// SyncContext works like Sync, but returns the error of ctx if it is done before the logs are written,
+// e.g. to bound the time of flushing before exiting
+func SyncContext(ctx context.Context) error {
+    if s, ok := logger.GetDefaultLogger().(interface{ SyncContext(context.Context) error }); ok {
+        return s.SyncContext(ctx)
+    }
+    return Sync()
+}
+
+// Close flushes the logs, closes all file outputs of the default logger and stops their background
+// goroutines. Call it before exiting, or on the old logger before swapping configurations by SetLoggerConfig
+func Close() error {
+    if c, ok := logger.GetDefaultLogger().(io.Closer); ok {
+        return c.Close()
+    }
+    return nil
+}
+
+// Stats returns the statistics of file outputs in async write modes, like the number of queued, written and
+// dropped logs, keyed by their file paths
+func Stats() map[string]config.AsyncStats {
+    if s, ok := logger.GetDefaultLogger().(interface{ Stats() map[string]config.AsyncStats }); ok {
+        return s.Stats()
+    }
+    return nil
+}
+
+// Plan returns the files which the retention sweeps of file outputs would compress or delete now, keyed by
+// their file paths, without touching them. e.g. to check which files MaxAge, MaxBackups or quotas remove
+func Plan() (map[string]config.CleanPlan, error) {
+    if p, ok := logger.GetDefaultLogger().(interface{ Plan() (map[string]config.CleanPlan, error) }); ok {
+        return p.Plan()
+    }
+    return nil, nil
+}
+
+// PublishStats publishes Stats of the default logger by expvar with the name, like "zlog". It panics if
+// the name is already published
+func PublishStats(name string) {
+    expvar.Publish(name, expvar.Func(func() interface{} {
+        return Stats()
+    }))
+}
+
+// Rotate backs up the current files of all file outputs and opens new ones, e.g. on deploy
+func Rotate() error {
+    if r, ok := logger.GetDefaultLogger().(writer.Rotator); ok {
+        return r.Rotate()
+    }
+    return nil
+}
+
+// Reopen reopens the current files of all file outputs, e.g. after they are moved by external logrotate
+func Reopen() error {
+    if r, ok := logger.GetDefaultLogger().(writer.Rotator); ok {
+        return r.Reopen()
+    }
+    return nil
+}
+
+// ReopenOnSIGHUP reopens all file outputs on receiving SIGHUP, so that external logrotate can work with
+// `postrotate kill -HUP`. It returns a function to stop handling the signal.
+func ReopenOnSIGHUP() (stop func()) {
+    ch := make(chan os.Signal, 1)
+    done := make(chan struct{})
+    signal.Notify(ch, syscall.SIGHUP)
+    go func() {
+        for {
+            select {
+            case <-ch:
+                _ = Reopen()
+            case <-done:
+                return
+            }
+        }
+    }()
+    return func() {
+        signal.Stop(ch)
+        close(done)
+    }
+}